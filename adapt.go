@@ -22,6 +22,7 @@ import (
 	"golang.org/x/tools/go/packages"
 
 	rpast "github.com/bobg/godef/go/ast"
+	rpparser "github.com/bobg/godef/go/parser"
 	rpprinter "github.com/bobg/godef/go/printer"
 	rptypes "github.com/bobg/godef/go/types"
 )
@@ -45,6 +46,10 @@ const (
 )
 
 func (b *triBool) Set(s string) error {
+	if s == "default" {
+		*b = unset
+		return nil
+	}
 	v, err := strconv.ParseBool(s)
 	if v {
 		*b = on
@@ -85,23 +90,56 @@ func detectModuleMode(cfg *packages.Config) bool {
 			return true
 		}
 	}
-	// do a fast test for go.mod in the working directory
+	// do a fast test for go.mod in the working directory, or for
+	// go.work in it or in its parents, where the go command looks
 	if _, err := os.Stat(filepath.Join(cfg.Dir, "go.mod")); !os.IsNotExist(err) {
 		return true
 	}
+	if rptypes.Getenv(cfg.Env, "GOWORK") != "off" && rptypes.FindUp(cfg.Dir, "go.work") != "" {
+		return true
+	}
 	// fall back to invoking the go tool to see if it will pick module mode
-	cmd := exec.Command("go", "env", "GOMOD")
+	if pkgCache != nil {
+		return pkgCache.goEnvModuleMode(cfg)
+	}
+	return goEnvModuleMode(cfg)
+}
+
+// goEnvModuleMode asks the go tool whether it will use module mode,
+// either in a module or in a workspace.
+func goEnvModuleMode(cfg *packages.Config) bool {
+	cmd := exec.Command("go", "env", "GOMOD", "GOWORK")
 	cmd.Env = cfg.Env
 	cmd.Dir = cfg.Dir
 	out, err := cmd.Output()
 	if err == nil {
-		return len(strings.TrimSpace(string(out))) > 0
+		// go env prints GOWORK=off as it is.
+		gomod, gowork, _ := strings.Cut(string(out), "\n")
+		gowork = strings.TrimSpace(gowork)
+		return strings.TrimSpace(gomod) != "" || gowork != "" && gowork != "off"
 	}
 	// default to non module mode
 	return false
 }
 
-func adaptGodef(cfg *packages.Config, filename string, src []byte, searchpos int) (*Object, error) {
+// godefOptions holds the options that change what adaptGodef finds.
+type godefOptions struct {
+	// typeInfo makes the legacy implementation read the other
+	// files of the package, to find complete type information.
+	typeInfo bool
+	// typeDef finds the declaration of the type of the
+	// identifier instead of the identifier.
+	typeDef bool
+	// doc reads the doc comment of the declaration.
+	doc bool
+}
+
+// flagOptions returns the options given on the command line.
+func flagOptions() godefOptions {
+	return godefOptions{typeInfo: *tflag, typeDef: *typeDefFlag, doc: *docFlag}
+}
+
+func adaptGodef(cfg *packages.Config, filename string, src []byte, searchpos int, expr string, opts godefOptions) (*Object, error) {
 	usePackages := false
 	switch forcePackages {
 	case unset:
@@ -111,18 +149,43 @@ func adaptGodef(cfg *packages.Config, filename string, src []byte, searchpos int
 	case off:
 		usePackages = false
 	}
+	var result *Object
 	if usePackages {
-		fset, obj, err := godefPackages(cfg, filename, src, searchpos)
+		fset, obj, err := godefPackages(cfg, filename, src, searchpos, expr)
+		if err != nil {
+			return nil, err
+		}
+		if opts.typeDef {
+			// Predeclared types have no position, but
+			// are documented in package builtin.
+			tobj := namedTypeOf(obj.Type())
+			if tobj == nil || !tobj.Pos().IsValid() && goPredeclaredPos(tobj).Filename == "" {
+				return nil, fmt.Errorf("no type declaration found for %s", obj.Name())
+			}
+			obj = tobj
+		}
+		if result, err = adaptGoObject(fset, obj); err != nil {
+			return nil, err
+		}
+	} else {
+		obj, typ, err := godef(filename, src, searchpos, expr, cfg.Overlay, opts)
 		if err != nil {
 			return nil, err
 		}
-		return adaptGoObject(fset, obj)
+		if opts.typeDef {
+			name := obj.Name
+			if obj, typ = typ.TypeName(); obj == nil || !rptypes.DeclPos(obj).IsValid() && rpPredeclaredName(obj) == "" {
+				return nil, fmt.Errorf("no type declaration found for %s", name)
+			}
+		}
+		if result, err = adaptRPObject(obj, typ); err != nil {
+			return nil, err
+		}
 	}
-	obj, typ, err := godef(filename, src, searchpos)
-	if err != nil {
-		return nil, err
+	if opts.doc {
+		result.Doc = docComment(result.Position, filename, src)
 	}
-	return adaptRPObject(obj, typ)
+	return result, nil
 }
 
 func adaptRPObject(obj *rpast.Object, typ rptypes.Type) (*Object, error) {
@@ -137,6 +200,10 @@ func adaptRPObject(obj *rpast.Object, typ rptypes.Type) (*Object, error) {
 		},
 		Type: typ,
 	}
+	if name := rpPredeclaredName(obj); !pos.IsValid() && name != "" {
+		// Predeclared objects are documented in package builtin.
+		result.Position = predeclaredPos("builtin", name)
+	}
 	switch obj.Kind {
 	case rpast.Bad:
 		result.Kind = BadKind
@@ -156,7 +223,18 @@ func adaptRPObject(obj *rpast.Object, typ rptypes.Type) (*Object, error) {
 	case rpast.Con:
 		result.Kind = ConstKind
 		if decl, ok := obj.Decl.(*rpast.ValueSpec); ok {
-			result.Value = decl.Values[0]
+			for i, n := range decl.Names {
+				if n.Name != obj.Name || i >= len(decl.Values) {
+					continue
+				}
+				// Report the value of a constant expression,
+				// as go/packages does, and otherwise the
+				// expression itself.
+				result.Value = decl.Values[i]
+				if v := rptypes.ConstValue(decl.Values[i]); v != nil {
+					result.Value = v
+				}
+			}
 		}
 	case rpast.Lbl:
 		result.Kind = LabelKind
@@ -176,12 +254,50 @@ func adaptRPObject(obj *rpast.Object, typ rptypes.Type) (*Object, error) {
 	return result, nil
 }
 
+// rpPredeclaredName returns the name under which obj, a predeclared
+// object or a method of a predeclared type, is documented in package
+// builtin, as in error.Error, or "" if it is neither.
+func rpPredeclaredName(obj *rpast.Object) string {
+	if rpparser.Universe.Lookup(obj.Name) == obj {
+		return obj.Name
+	}
+	for name, t := range rpparser.Universe.Objects {
+		ts, ok := t.Decl.(*rpast.TypeSpec)
+		if !ok {
+			continue
+		}
+		if it, ok := ts.Type.(*rpast.InterfaceType); ok {
+			for _, m := range it.Methods.List {
+				for _, id := range m.Names {
+					if id.Obj == obj {
+						return name + "." + obj.Name
+					}
+				}
+			}
+		}
+	}
+	return ""
+}
+
 func adaptGoObject(fset *gotoken.FileSet, obj gotypes.Object) (*Object, error) {
+	result := goObject(fset, obj)
+	for _, m := range goMembers(obj) {
+		result.Members = append(result.Members, goObject(fset, m))
+	}
+	sort.Sort(orderedObjects(result.Members))
+	return result, nil
+}
+
+// goObject adapts obj, without its members.
+func goObject(fset *gotoken.FileSet, obj gotypes.Object) *Object {
 	result := &Object{
 		Name:     obj.Name(),
 		Position: objToPos(fset, obj),
 		Type:     obj.Type(),
 	}
+	if obj.Pkg() != nil {
+		result.Pkg = obj.Pkg().Path()
+	}
 	switch obj := obj.(type) {
 	case *gotypes.Func:
 		result.Kind = FuncKind
@@ -202,19 +318,100 @@ func adaptGoObject(fset *gotoken.FileSet, obj gotypes.Object) (*Object, error) {
 	case *gotypes.Label:
 		result.Kind = LabelKind
 		result.Type = nil
+	case *gotypes.Builtin:
+		// Builtin functions have no signature of their own.
+		result.Kind = FuncKind
+		result.Type = nil
 	case *gotypes.TypeName:
 		result.Kind = TypeKind
 		result.Type = obj.Type().Underlying()
 	default:
 		result.Kind = BadKind
 	}
+	return result
+}
 
-	return result, nil
+// goMembers returns the members of obj: for an imported package, the
+// objects it declares; otherwise, the fields and methods, including
+// promoted ones, that can be selected from a variable of obj's type.
+func goMembers(obj gotypes.Object) []gotypes.Object {
+	switch obj := obj.(type) {
+	case *gotypes.PkgName:
+		scope := obj.Imported().Scope()
+		var result []gotypes.Object
+		for _, name := range scope.Names() {
+			result = append(result, scope.Lookup(name))
+		}
+		return result
+	case *gotypes.TypeName, *gotypes.Var:
+		return selectable(obj.Type())
+	}
+	return nil
+}
+
+// selectable returns the fields and methods, including promoted ones,
+// that can be selected from an addressable variable of type t.
+func selectable(t gotypes.Type) []gotypes.Object {
+	// Collect every name that might be selected, then let the type
+	// checker apply the rules for shadowing and ambiguity.
+	candidates := make(map[string]*gotypes.Package)
+	fieldNames(t, candidates, make(map[*gotypes.Named]bool))
+	mt := t
+	if _, ok := t.Underlying().(*gotypes.Interface); !ok {
+		if _, ok := gotypes.Unalias(t).(*gotypes.Pointer); !ok {
+			mt = gotypes.NewPointer(t)
+		}
+	}
+	mset := gotypes.NewMethodSet(mt)
+	for i := 0; i < mset.Len(); i++ {
+		m := mset.At(i).Obj()
+		candidates[m.Name()] = m.Pkg()
+	}
+	var result []gotypes.Object
+	for name, pkg := range candidates {
+		if m, _, _ := gotypes.LookupFieldOrMethod(t, true, pkg, name); m != nil {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// fieldNames adds to names the name and package of every field of t,
+// including those of embedded fields at any depth.
+func fieldNames(t gotypes.Type, names map[string]*gotypes.Package, seen map[*gotypes.Named]bool) {
+	t = gotypes.Unalias(t)
+	if p, ok := t.(*gotypes.Pointer); ok {
+		t = gotypes.Unalias(p.Elem())
+	}
+	if n, ok := t.(*gotypes.Named); ok {
+		if seen[n] {
+			return
+		}
+		seen[n] = true
+	}
+	st, ok := t.Underlying().(*gotypes.Struct)
+	if !ok {
+		return
+	}
+	for i := 0; i < st.NumFields(); i++ {
+		f := st.Field(i)
+		if f.Name() != "_" {
+			names[f.Name()] = f.Pkg()
+		}
+		if f.Embedded() {
+			fieldNames(f.Type(), names, seen)
+		}
+	}
 }
 
 func objToPos(fSet *gotoken.FileSet, obj gotypes.Object) Position {
 	p := obj.Pos()
 	f := fSet.File(p)
+	if f == nil {
+		// Predeclared objects, such as the Error method, have no
+		// position, but are documented in package builtin.
+		return goPredeclaredPos(obj)
+	}
 	goPos := f.Position(p)
 	pos := Position{
 		Filename: cleanFilename(goPos.Filename),
@@ -248,6 +445,23 @@ func objToPos(fSet *gotoken.FileSet, obj gotypes.Object) Position {
 	return pos
 }
 
+// goPredeclaredPos returns the position of the documentation
+// declaration of obj, a predeclared object or one of package unsafe.
+func goPredeclaredPos(obj gotypes.Object) Position {
+	switch obj.Pkg() {
+	case gotypes.Unsafe:
+		return predeclaredPos("unsafe", obj.Name())
+	case nil:
+		if fn, ok := obj.(*gotypes.Func); ok {
+			if recv := fn.Type().(*gotypes.Signature).Recv(); recv != nil {
+				return predeclaredPos("builtin", gotypes.TypeString(recv.Type(), nil)+"."+obj.Name())
+			}
+		}
+		return predeclaredPos("builtin", obj.Name())
+	}
+	return Position{}
+}
+
 // cleanFilename normalizes any file names that come out of the fileset.
 func cleanFilename(path string) string {
 	const prefix = "$GOROOT"
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// batchRequest is a single query read by -batch.
+// Offset is ignored when Expr is given.
+type batchRequest struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	File   string          `json:"file"`
+	Offset *int            `json:"offset,omitempty"`
+	Expr   string          `json:"expr,omitempty"`
+}
+
+// batchResult is written by -batch in reply to each batchRequest.
+type batchResult struct {
+	ID       json.RawMessage `json:"id,omitempty"`
+	Position *Position       `json:"position,omitempty"`
+	Path     string          `json:"path,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// batch reads newline-delimited JSON requests from in and writes one
+// JSON result per line to out, in the same order. Packages are loaded
+// once and shared by all requests for files in the same package.
+func batch(ctx context.Context, dir string, env []string, in io.Reader, out io.Writer) error {
+	if pkgCache == nil {
+		pkgCache = newPackageCache()
+	}
+	enc := json.NewEncoder(out)
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(nil, 1<<24)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var req batchRequest
+		var result *batchResult
+		if err := json.Unmarshal([]byte(line), &req); err != nil {
+			result = &batchResult{Error: fmt.Sprintf("invalid request: %v", err)}
+		} else {
+			result = batchQuery(ctx, dir, env, &req)
+		}
+		if err := enc.Encode(result); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// batchQuery answers a single batch request.
+func batchQuery(ctx context.Context, dir string, env []string, req *batchRequest) *batchResult {
+	result := &batchResult{ID: req.ID}
+	filename := req.File
+	if dir != "" && !filepath.IsAbs(filename) {
+		filename = filepath.Join(dir, filename)
+	}
+	searchpos := -1
+	if req.Offset != nil {
+		searchpos = *req.Offset
+	}
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		result.Error = fmt.Sprintf("cannot read %s: %v", filename, err)
+		return result
+	}
+	cfg := &packages.Config{
+		Context: ctx,
+		Dir:     dir,
+		Env:     env,
+		Tests:   strings.HasSuffix(filename, "_test.go"),
+	}
+	configureBuild(cfg)
+	obj, err := adaptGodef(cfg, filename, src, searchpos, req.Expr, flagOptions())
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if obj.Kind == PathKind {
+		result.Path = fmt.Sprint(obj.Value)
+	} else {
+		result.Position = &obj.Position
+	}
+	return result
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/types"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// pkgCache, if non-nil, holds packages loaded by earlier queries so
+// that later queries can reuse them. It is only set by the server.
+var pkgCache *packageCache
+
+// packageCache holds fully type-checked packages, keyed by the directory
+// of the file they were loaded for and the configuration used to load them.
+type packageCache struct {
+	mu         sync.Mutex
+	entries    map[string]*cacheEntry
+	moduleMode map[string]bool
+}
+
+// cacheEntry holds the result of a single load, along with
+// what is needed to tell whether it is still up to date. Only the
+// files and directories of the packages loaded, and the go.mod and
+// go.sum files of their modules, are checked, so that a query does
+// not stat every file of every dependency; changes to dependencies
+// are noticed only when they are in the overlay. Filename is the file
+// of the query that loaded the packages, the only one parsed again
+// where syntax errors spoilt it.
+type cacheEntry struct {
+	filename string
+	lpkgs    []*packages.Package
+	overlay  map[string][]byte
+	modTimes map[string]time.Time
+}
+
+func newPackageCache() *packageCache {
+	return &packageCache{
+		entries:    make(map[string]*cacheEntry),
+		moduleMode: make(map[string]bool),
+	}
+}
+
+// loadObject is like the loadObject function, but reuses the packages
+// loaded for an earlier query when none of their files have changed.
+// Function bodies are never trimmed, so that the same packages can
+// answer queries at any position.
+func (c *packageCache) loadObject(cfg *packages.Config, filename string, src []byte, searchpos int) (*packages.Package, types.Object, error) {
+	if src != nil {
+		cfg.Overlay = withOverlay(cfg.Overlay, filename, src)
+	}
+	cfg.Mode = packages.LoadSyntax | packages.NeedModule
+	cfg.ParseFile = parseGoFile(filename)
+	lpkgs, err := c.load(cfg, filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	isInputFile := newFileCompare(filename)
+	for _, lpkg := range lpkgs {
+		obj, err := findObject(lpkg, isInputFile, searchpos)
+		if err != nil {
+			return nil, nil, err
+		}
+		if obj != nil {
+			return lpkg, obj, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("There must be at least one package that contains the file")
+}
+
+// load returns the packages containing filename,
+// loading them only if there is no valid cache entry.
+func (c *packageCache) load(cfg *packages.Config, filename string) ([]*packages.Package, error) {
+	key := strings.Join([]string{
+		filepath.Dir(filename),
+		cfg.Dir,
+		strconv.FormatBool(cfg.Tests),
+		strings.Join(cfg.BuildFlags, " "),
+		envKey(cfg.Env),
+	}, "\x00")
+	c.mu.Lock()
+	e := c.entries[key]
+	c.mu.Unlock()
+	if e != nil && e.valid(cfg.Overlay, filename) {
+		return e.lpkgs, nil
+	}
+	lpkgs, err := packages.Load(cfg, "file="+filename)
+	if err != nil {
+		return nil, err
+	}
+	e = &cacheEntry{
+		filename: filename,
+		lpkgs:    lpkgs,
+		overlay:  cfg.Overlay,
+		modTimes: make(map[string]time.Time),
+	}
+	for _, lpkg := range lpkgs {
+		for _, f := range lpkg.CompiledGoFiles {
+			e.modTimes[f] = modTime(f)
+			// Adding or removing a file changes its directory.
+			e.modTimes[filepath.Dir(f)] = modTime(filepath.Dir(f))
+		}
+		if lpkg.Module != nil && lpkg.Module.GoMod != "" {
+			e.modTimes[lpkg.Module.GoMod] = modTime(lpkg.Module.GoMod)
+			sum := strings.TrimSuffix(lpkg.Module.GoMod, ".mod") + ".sum"
+			e.modTimes[sum] = modTime(sum)
+		}
+	}
+	c.mu.Lock()
+	c.entries[key] = e
+	c.mu.Unlock()
+	return lpkgs, nil
+}
+
+// valid reports whether the entry can answer a query on filename
+// with the given overlay.
+func (e *cacheEntry) valid(overlay map[string][]byte, filename string) bool {
+	if len(overlay) != len(e.overlay) {
+		return false
+	}
+	for name, data := range overlay {
+		if old, ok := e.overlay[name]; !ok || !bytes.Equal(old, data) {
+			return false
+		}
+	}
+	for name, t := range e.modTimes {
+		if !modTime(name).Equal(t) {
+			return false
+		}
+	}
+	isInputFile := newFileCompare(filename)
+	if !isInputFile(e.filename) {
+		for _, lpkg := range e.lpkgs {
+			for _, err := range lpkg.Errors {
+				if err.Kind == packages.ParseError {
+					return false
+				}
+			}
+		}
+	}
+	for _, lpkg := range e.lpkgs {
+		for _, f := range lpkg.CompiledGoFiles {
+			if isInputFile(f) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// goEnvModuleMode is like the goEnvModuleMode function,
+// but remembers the answer for each directory and environment.
+func (c *packageCache) goEnvModuleMode(cfg *packages.Config) bool {
+	dir := cfg.Dir
+	if dir == "" {
+		dir, _ = os.Getwd()
+	}
+	key := dir + "\x00" + envKey(cfg.Env)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	mode, ok := c.moduleMode[key]
+	if !ok {
+		mode = goEnvModuleMode(cfg)
+		c.moduleMode[key] = mode
+	}
+	return mode
+}
+
+// envKey returns a cache key for the environment env, ignoring the
+// variables that change with every shell directory but do not affect
+// the go command.
+func envKey(env []string) string {
+	var kept []string
+	for _, e := range env {
+		if !strings.HasPrefix(e, "PWD=") && !strings.HasPrefix(e, "OLDPWD=") {
+			kept = append(kept, e)
+		}
+	}
+	return strings.Join(kept, "\x00")
+}
+
+// modTime returns the modification time of the named file,
+// or the zero time if it cannot be found.
+func modTime(name string) time.Time {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
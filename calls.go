@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"io"
+	"sort"
+
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/packages"
+)
+
+// Call is a call site printed by -callers, or a called function printed
+// by -callees. Name is the calling or called function. A call is dynamic
+// when it is made through an interface method or a method value, so that
+// the function actually called, or when, is only known at run time.
+type Call struct {
+	Position
+	Name    string `json:"name"`
+	Dynamic bool   `json:"dynamic,omitempty"`
+}
+
+// godefCallers returns the sites of the calls to the function or method
+// referred to by the identifier at searchpos, searching the same packages
+// as godefRefs. For a concrete method, the calls of the interface methods
+// it implements, and its method values, are included as dynamic calls.
+func godefCallers(cfg *packages.Config, filename string, src []byte, searchpos int) ([]Call, error) {
+	lpkgs, targets, err := loadDependents(cfg, filename, src, searchpos)
+	if err != nil {
+		return nil, err
+	}
+	dynamic := make(map[types.Object]bool)
+	named := namedTypes(lpkgs)
+	for target := range targets {
+		fn, ok := target.(*types.Func)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a function", target.Name())
+		}
+		recv := fn.Type().(*types.Signature).Recv()
+		if recv == nil || types.IsInterface(recv.Type()) {
+			continue
+		}
+		for _, t := range named {
+			if iface, ok := t.Underlying().(*types.Interface); ok && implements(recv.Type(), iface) {
+				if m := lookupMethod(t, fn); m != nil {
+					dynamic[m] = true
+				}
+			}
+		}
+	}
+	seen := make(map[Position]bool)
+	var result []Call
+	for _, lpkg := range lpkgs {
+		for _, f := range lpkg.Syntax {
+			for _, decl := range f.Decls {
+				caller := "init"
+				if fd, ok := decl.(*ast.FuncDecl); ok {
+					if fn, ok := lpkg.TypesInfo.Defs[fd.Name].(*types.Func); ok {
+						caller = funcName(fn, lpkg.Types)
+					}
+				}
+				inspectCalls(lpkg.TypesInfo, decl, func(id *ast.Ident, fn *types.Func, isValue bool) {
+					fn = fn.Origin()
+					if !targets[fn] && !dynamic[fn] {
+						return
+					}
+					pos := identPos(lpkg.Fset, id)
+					if !seen[pos] {
+						seen[pos] = true
+						result = append(result, Call{
+							Position: pos,
+							Name:     caller,
+							Dynamic:  isValue || dynamic[fn] || isInterfaceMethod(fn),
+						})
+					}
+				})
+			}
+		}
+	}
+	sort.Sort(orderedCalls(result))
+	return result, nil
+}
+
+// godefCallees returns the declarations of the functions and methods
+// called directly by the function or method referred to by the identifier
+// at searchpos, including calls made by function literals within it, in
+// the order in which they are first called. Method values are included as
+// dynamic calls; other function values are not, as their callee is unknown.
+func godefCallees(cfg *packages.Config, filename string, src []byte, searchpos int) ([]Call, error) {
+	base := *cfg
+	lpkg, obj, err := loadObject(cfg, filename, src, searchpos)
+	if err != nil {
+		return nil, err
+	}
+	fn, ok := obj.(*types.Func)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a function", obj.Name())
+	}
+	fn = fn.Origin()
+	declPos := lpkg.Fset.Position(fn.Pos())
+	if !declPos.IsValid() {
+		return nil, fmt.Errorf("no declaration found for %s", fn.Name())
+	}
+	lpkgs, err := loadSyntax(&base, src, filename, "file="+declPos.Filename)
+	if err != nil {
+		return nil, err
+	}
+	for _, lpkg := range lpkgs {
+		decl := funcDecl(lpkg, fn.Name(), declPos)
+		if decl == nil || decl.Body == nil {
+			continue
+		}
+		seen := make(map[types.Object]bool)
+		var result []Call
+		inspectCalls(lpkg.TypesInfo, decl.Body, func(_ *ast.Ident, fn *types.Func, isValue bool) {
+			if seen[fn.Origin()] || !fn.Pos().IsValid() {
+				return
+			}
+			fn = fn.Origin()
+			seen[fn] = true
+			result = append(result, Call{
+				Position: objToPos(lpkg.Fset, fn),
+				Name:     funcName(fn, lpkg.Types),
+				Dynamic:  isValue || isInterfaceMethod(fn),
+			})
+		})
+		return result, nil
+	}
+	return nil, fmt.Errorf("no body found for %s", fn.Name())
+}
+
+// inspectCalls calls visit, in source order, for each call in n of a
+// declared function or method, with the identifier naming it, and for
+// each method value in n, such as x.M in f(x.M), which may be called
+// later through the function value it denotes.
+func inspectCalls(info *types.Info, n ast.Node, visit func(id *ast.Ident, fn *types.Func, isValue bool)) {
+	called := make(map[*ast.Ident]bool)
+	ast.Inspect(n, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.CallExpr:
+			if id, fn := callee(info, n); fn != nil {
+				called[id] = true
+				visit(id, fn, false)
+			}
+		case *ast.SelectorExpr:
+			if sel, ok := info.Selections[n]; ok && sel.Kind() == types.MethodVal && !called[n.Sel] {
+				if fn, ok := sel.Obj().(*types.Func); ok {
+					visit(n.Sel, fn, true)
+				}
+			}
+		}
+		return true
+	})
+}
+
+// callee returns the identifier naming the function called by call,
+// and the function or method it refers to, or nil if call is not a
+// call of a declared function or method.
+func callee(info *types.Info, call *ast.CallExpr) (*ast.Ident, *types.Func) {
+	fun := astutil.Unparen(call.Fun)
+	switch f := fun.(type) {
+	case *ast.IndexExpr:
+		fun = f.X
+	case *ast.IndexListExpr:
+		fun = f.X
+	}
+	var id *ast.Ident
+	switch f := fun.(type) {
+	case *ast.Ident:
+		id = f
+	case *ast.SelectorExpr:
+		id = f.Sel
+	default:
+		return nil, nil
+	}
+	fn, _ := info.Uses[id].(*types.Func)
+	return id, fn
+}
+
+// funcDecl returns the declaration in lpkg of the function with the
+// given name declared at pos, or nil if lpkg does not declare it. Only
+// the line of pos is compared, as the columns recorded in export data
+// are not reliable.
+func funcDecl(lpkg *packages.Package, name string, pos token.Position) *ast.FuncDecl {
+	isDeclFile := newFileCompare(pos.Filename)
+	for _, f := range lpkg.Syntax {
+		tfile := lpkg.Fset.File(f.Pos())
+		if tfile == nil || !isDeclFile(tfile.Name()) {
+			continue
+		}
+		for _, decl := range f.Decls {
+			if fd, ok := decl.(*ast.FuncDecl); ok && fd.Name.Name == name && tfile.Line(fd.Name.Pos()) == pos.Line {
+				return fd
+			}
+		}
+	}
+	return nil
+}
+
+// isInterfaceMethod reports whether fn is an abstract method.
+func isInterfaceMethod(fn *types.Func) bool {
+	recv := fn.Type().(*types.Signature).Recv()
+	return recv != nil && types.IsInterface(recv.Type())
+}
+
+// funcName returns the name of fn as written in package from,
+// with the receiver type for a method.
+func funcName(fn *types.Func, from *types.Package) string {
+	qualifier := func(pkg *types.Package) string {
+		if pkg == from {
+			return ""
+		}
+		return pkg.Name()
+	}
+	if recv := fn.Type().(*types.Signature).Recv(); recv != nil {
+		return fmt.Sprintf("(%s).%s", types.TypeString(recv.Type(), qualifier), fn.Name())
+	}
+	if fn.Pkg() != nil && fn.Pkg() != from {
+		return fn.Pkg().Name() + "." + fn.Name()
+	}
+	return fn.Name()
+}
+
+// printCalls prints calls in the same way as printPositions,
+// followed by the name of the function and whether the call is dynamic.
+func printCalls(out io.Writer, calls []Call) error {
+	if *jsonFlag {
+		if calls == nil {
+			calls = []Call{}
+		}
+		jsonStr, err := json.Marshal(calls)
+		if err != nil {
+			return fmt.Errorf("JSON marshal error: %v", err)
+		}
+		fmt.Fprintf(out, "%s\n", jsonStr)
+		return nil
+	}
+	for _, call := range calls {
+		fmt.Fprintf(out, "%v\t%s", call.Position, call.Name)
+		if call.Dynamic {
+			fmt.Fprint(out, "\t(dynamic)")
+		}
+		fmt.Fprintln(out)
+	}
+	return nil
+}
+
+type orderedCalls []Call
+
+func (o orderedCalls) Len() int      { return len(o) }
+func (o orderedCalls) Swap(i, j int) { o[i], o[j] = o[j], o[i] }
+func (o orderedCalls) Less(i, j int) bool {
+	return orderedPositions{o[i].Position, o[j].Position}.Less(0, 1)
+}
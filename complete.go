@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// godefComplete returns the candidates for completing the identifier
+// that ends at searchpos, or that would begin there. After a selector
+// period, they are the fields and methods that can be selected from the
+// expression before it, or the exported names of the package it names;
+// otherwise, they are the names in scope at searchpos, from the local
+// ones out to the predeclared ones. Only the candidates beginning with
+// the part of the identifier before searchpos are returned.
+func godefComplete(cfg *packages.Config, filename string, src []byte, searchpos int) ([]*Object, error) {
+	if searchpos < 0 || searchpos > len(src) {
+		return nil, fmt.Errorf("cursor %d is beyond end of file %s (%d)", searchpos, filename, len(src))
+	}
+	start := searchpos
+	for start > 0 {
+		r, size := utf8.DecodeLastRune(src[:start])
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			break
+		}
+		start -= size
+	}
+	prefix := string(src[start:searchpos])
+
+	lpkgs, err := loadSyntax(cfg, src, filename, "file="+filename)
+	if err != nil {
+		return nil, err
+	}
+	isInputFile := newFileCompare(filename)
+	for _, lpkg := range lpkgs {
+		for _, f := range lpkg.Syntax {
+			tfile := lpkg.Fset.File(f.Pos())
+			if tfile == nil || !isInputFile(tfile.Name()) {
+				continue
+			}
+			var objs []types.Object
+			if start > 0 && src[start-1] == '.' {
+				if objs, err = selectorCandidates(lpkg, f, tfile.Pos(start-1)); err != nil {
+					return nil, err
+				}
+			} else {
+				objs = scopeCandidates(lpkg, f, tfile.Pos(start))
+			}
+			var result []*Object
+			for _, obj := range objs {
+				if strings.HasPrefix(obj.Name(), prefix) && obj.Name() != "_" {
+					result = append(result, goObject(lpkg.Fset, obj))
+				}
+			}
+			sort.Sort(orderedObjects(result))
+			return result, nil
+		}
+	}
+	return nil, fmt.Errorf("There must be at least one package that contains the file")
+}
+
+// selectorCandidates returns the objects that can be selected from the
+// operand of the selector expression in f whose period is at dot.
+func selectorCandidates(lpkg *packages.Package, f *ast.File, dot token.Pos) ([]types.Object, error) {
+	// The innermost selector is the one whose operand ends
+	// last. After a lone period, the parser supplies a
+	// placeholder for the missing selector.
+	var sel *ast.SelectorExpr
+	ast.Inspect(f, func(n ast.Node) bool {
+		if n == nil || n.Pos() > dot || n.End() <= dot {
+			return false
+		}
+		if s, ok := n.(*ast.SelectorExpr); ok && s.X.End() <= dot && s.Sel.Pos() > dot {
+			if sel == nil || s.X.End() > sel.X.End() {
+				sel = s
+			}
+		}
+		return true
+	})
+	if sel == nil {
+		return nil, fmt.Errorf("no selector expression at the cursor")
+	}
+	var result []types.Object
+	if id, ok := ast.Unparen(sel.X).(*ast.Ident); ok {
+		if pkgName, ok := lpkg.TypesInfo.Uses[id].(*types.PkgName); ok {
+			scope := pkgName.Imported().Scope()
+			for _, name := range scope.Names() {
+				if obj := scope.Lookup(name); obj.Exported() {
+					result = append(result, obj)
+				}
+			}
+			return result, nil
+		}
+	}
+	tv, ok := lpkg.TypesInfo.Types[sel.X]
+	if !ok || tv.Type == nil || tv.Type == types.Typ[types.Invalid] {
+		return nil, fmt.Errorf("no type for %s", types.ExprString(sel.X))
+	}
+	var objs []types.Object
+	if tv.IsType() {
+		// Method expressions select from the method set of the type itself.
+		mset := types.NewMethodSet(tv.Type)
+		for i := 0; i < mset.Len(); i++ {
+			objs = append(objs, mset.At(i).Obj())
+		}
+	} else {
+		objs = selectable(tv.Type)
+	}
+	for _, obj := range objs {
+		if obj.Exported() || obj.Pkg() == lpkg.Types {
+			result = append(result, obj)
+		}
+	}
+	return result, nil
+}
+
+// scopeCandidates returns the objects that are in scope at pos in f,
+// leaving out those hidden by others of the same name.
+func scopeCandidates(lpkg *packages.Package, f *ast.File, pos token.Pos) []types.Object {
+	scope := lpkg.Types.Scope().Innermost(pos)
+	if scope == nil {
+		scope = lpkg.TypesInfo.Scopes[f]
+	}
+	if scope == nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var result []types.Object
+	for s := scope; s != nil; s = s.Parent() {
+		for _, name := range s.Names() {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			// Objects declared after pos are not yet in scope,
+			// so LookupParent finds any they would hide.
+			if _, obj := scope.LookupParent(name, pos); obj != nil {
+				result = append(result, obj)
+			}
+		}
+	}
+	return result
+}
+
+// printCandidates prints the completion candidates one per line,
+// each as its name, kind and type, separated by tabs. With -json,
+// they are printed as a JSON array of objects of the same form as
+// the members of a definition.
+func printCandidates(out io.Writer, objs []*Object) error {
+	if *jsonFlag {
+		candidates := []*jsonObject{}
+		for _, obj := range objs {
+			candidates = append(candidates, jsonMember(obj))
+		}
+		jsonStr, err := json.Marshal(candidates)
+		if err != nil {
+			return fmt.Errorf("JSON marshal error: %v", err)
+		}
+		fmt.Fprintf(out, "%s\n", jsonStr)
+		return nil
+	}
+	for _, obj := range objs {
+		j := jsonMember(obj)
+		fmt.Fprintf(out, "%s\t%s", j.Name, j.Kind)
+		if j.Type != "" {
+			fmt.Fprintf(out, "\t%s", j.Type)
+		}
+		fmt.Fprintln(out)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/build"
+	"io"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+
+	"github.com/bobg/godef/go/types"
+)
+
+// defaultConfigs is the default value of the -configs flag.
+const defaultConfigs = "linux/amd64,darwin/arm64,windows/amd64"
+
+// buildConfig is a build configuration: a target operating system and
+// architecture, and build tags. Empty fields are those of the host.
+type buildConfig struct {
+	goos, goarch string
+	tags         []string
+}
+
+func (c buildConfig) String() string {
+	s := c.goos + "/" + c.goarch
+	if len(c.tags) > 0 {
+		s += ":" + strings.Join(c.tags, "+")
+	}
+	return s
+}
+
+// configureBuild applies the -tags, -goos and -goarch flags to cfg,
+// and to the build context used by the legacy implementation.
+func configureBuild(cfg *packages.Config) {
+	buildConfig{
+		goos:   *goosFlag,
+		goarch: *goarchFlag,
+		tags:   splitTags(*tagsFlag),
+	}.apply(cfg)
+}
+
+// apply configures cfg, and the build context and environment used by
+// the legacy implementation, to build for c.
+func (c buildConfig) apply(cfg *packages.Config) {
+	types.BuildContext, types.Env = &build.Default, cfg.Env
+	if c.goos == "" && c.goarch == "" && len(c.tags) == 0 {
+		return
+	}
+	ctxt := build.Default
+	if len(c.tags) > 0 {
+		cfg.BuildFlags = append(slices.Clone(cfg.BuildFlags), "-tags="+strings.Join(c.tags, ","))
+		ctxt.BuildTags = append(slices.Clone(ctxt.BuildTags), c.tags...)
+	}
+	if c.goos != "" || c.goarch != "" {
+		if cfg.Env == nil {
+			cfg.Env = os.Environ()
+		}
+		cfg.Env = slices.Clone(cfg.Env)
+		if c.goos != "" {
+			cfg.Env = append(cfg.Env, "GOOS="+c.goos)
+			ctxt.GOOS = c.goos
+		}
+		if c.goarch != "" {
+			cfg.Env = append(cfg.Env, "GOARCH="+c.goarch)
+			ctxt.GOARCH = c.goarch
+		}
+		// As with the go command, cgo is disabled
+		// by default when cross-compiling.
+		if (ctxt.GOOS != runtime.GOOS || ctxt.GOARCH != runtime.GOARCH) && types.Getenv(cfg.Env, "CGO_ENABLED") != "1" {
+			ctxt.CgoEnabled = false
+		}
+	}
+	types.BuildContext, types.Env = &ctxt, cfg.Env
+}
+
+// splitTags splits a list of build tags separated by commas or spaces.
+func splitTags(tags string) []string {
+	return strings.FieldsFunc(tags, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+}
+
+// parseConfigs parses the value of the -configs flag.
+func parseConfigs(s string) ([]buildConfig, error) {
+	var configs []buildConfig
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		var c buildConfig
+		platform, tags, _ := strings.Cut(field, ":")
+		var ok bool
+		if c.goos, c.goarch, ok = strings.Cut(platform, "/"); !ok || c.goos == "" || c.goarch == "" {
+			return nil, fmt.Errorf("invalid build configuration %q, want goos/goarch[:tag+tag...]", field)
+		}
+		if tags != "" {
+			c.tags = strings.Split(tags, "+")
+		}
+		configs = append(configs, c)
+	}
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("no build configurations given")
+	}
+	return configs, nil
+}
+
+// configDef is a definition printed by -all-configs, with the
+// build configurations under which it is found.
+type configDef struct {
+	Position
+	Configs []string `json:"configs"`
+}
+
+// godefAllConfigs resolves the query under each of configs, with the tags
+// of -tags added to each, starting each time from cfg as it was before
+// configureBuild, and returns the distinct definitions found in
+// the order in which they are first found. Configurations under which
+// the query cannot be answered, such as those that exclude filename,
+// are left out.
+func godefAllConfigs(cfg *packages.Config, configs []buildConfig, filename string, src []byte, searchpos int, expr string, opts godefOptions) ([]*configDef, error) {
+	defer func(ctxt *build.Context, env []string) {
+		types.BuildContext, types.Env = ctxt, env
+	}(types.BuildContext, types.Env)
+	var defs []*configDef
+	found := make(map[Position]*configDef)
+	var firstErr error
+	for _, c := range configs {
+		c.tags = append(splitTags(*tagsFlag), c.tags...)
+		ccfg := *cfg
+		c.apply(&ccfg)
+		obj, err := adaptGodef(&ccfg, filename, src, searchpos, expr, opts)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%v: %v", c, err)
+			}
+			continue
+		}
+		def := found[obj.Position]
+		if def == nil {
+			def = &configDef{Position: obj.Position}
+			found[obj.Position] = def
+			defs = append(defs, def)
+		}
+		def.Configs = append(def.Configs, c.String())
+	}
+	if len(defs) == 0 {
+		return nil, firstErr
+	}
+	return defs, nil
+}
+
+// printConfigDefs prints each definition followed by its configurations,
+// or with -json, a JSON array of them.
+func printConfigDefs(out io.Writer, defs []*configDef) error {
+	if *jsonFlag {
+		jsonStr, err := json.Marshal(defs)
+		if err != nil {
+			return fmt.Errorf("JSON marshal error: %v", err)
+		}
+		fmt.Fprintf(out, "%s\n", jsonStr)
+		return nil
+	}
+	for _, def := range defs {
+		fmt.Fprintf(out, "%v\t%s\n", def.Position, strings.Join(def.Configs, " "))
+	}
+	return nil
+}
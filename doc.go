@@ -7,7 +7,14 @@ Godef prints the source location of definitions in Go programs.
 
 Usage:
 
-	godef [-t] [-a] [-A] [-o offset] [-i] [-f file][-acme] [expr]
+	godef [-t] [-a] [-A] [-T] [-doc] [-json] [-f file] [-o offset]
+		[-pos file:line:column] [-units bytes|runes|utf16] [-i] [-modified]
+		[-tags tags] [-goos os] [-goarch arch] [-socket path] [-acme]
+		[-refs | -impl | -callers | -callees | -complete | -signature |
+		-all-configs [-configs configs]] [expr]
+	godef -batch [-T] [-tags tags] [-goos os] [-goarch arch]
+	godef -lsp [-tags tags] [-goos os] [-goarch arch]
+	godef -serve [-socket path]
 
 File specifies the source file in which to evaluate expr.
 Expr must be an identifier or a Go expression
@@ -17,17 +24,158 @@ If expr is not given, then offset specifies a location
 within file, which should be within, or adjacent to
 an identifier or field selector.
 
+Instead of -f and -o, the -pos flag may give the location as
+file:line:column, with lines and columns counted from 1.
+The column is counted in bytes unless the -units flag says
+to count it in runes or in UTF-16 code units (utf16).
+
+Predeclared identifiers, such as len or error, have no source of
+their own, and are found at their declarations in the documentation
+source of package builtin in $GOROOT; the members of package unsafe
+are found likewise in its documentation source.
+
 If the -t flag is given, the type of the expression will
 also be printed. The -a flag causes all the public
 members (fields and methods) of the expression,
 and their location, to be printed also; the -A flag
 prints private members too.
 
+If the -T flag is given, godef finds the declaration of the type
+of the expression instead of the expression itself. Pointers, slices,
+arrays, maps and channels are followed to their element types, and
+functions to their first result, until a named type is reached.
+
+If the -doc flag is given, the doc comment of the declaration
+is printed after its location and type, or with -json, included
+as the "doc" field.
+
+With the -json flag, the definition is printed as a single JSON
+object. Its fields are:
+
+	version   the version of this format, currently 1
+	filename  the file containing the definition
+	line      the line of the definition, counted from 1
+	column    the column of the definition, in bytes, counted from 1
+	name      the name of the definition
+	kind      one of bad, func, var, import, const, label, type or path
+	pkg       the import path of the package declaring it, if known
+	type      its type; for a type, the underlying type
+	value     the value of a constant, the quoted path of an import,
+	          or for kind path, the imported package's directory
+	doc       the doc comment, with -doc
+	members   with -a or -A, the members, each an object of the
+	          same form without a version or members of its own
+
+Empty fields are omitted. Fields may be added without changing the
+version.
+
 If the -i flag is specified, the source is read
 from standard input, although file must still
 be specified so that other files in the same source
 package may be found.
 
+If the -modified flag is specified, an archive of files that have been
+modified but not saved is read from standard input, and their contents
+are used in place of those on disk. Each file in the archive is given
+by its name and its size in bytes, each on a line of its own, followed
+by its contents. File itself may be one of them. Without module mode,
+only the modified files in file's own package are used.
+
+The -tags flag gives a comma-separated list of build tags to apply,
+and the -goos and -goarch flags the target operating system and
+architecture, when selecting the files of each package. By default,
+they are those of the host.
+
+With the -all-configs flag, godef finds the definition under each of
+the build configurations listed by the -configs flag, and prints each
+distinct definition followed by the configurations that produce it.
+Each configuration is written goos/goarch, optionally followed by a
+colon and build tags separated by plus signs, as in linux/amd64:cgo+netgo;
+the tags of -tags are added to every configuration. Configurations that
+exclude the file are ignored. With -json, the definitions are printed
+as a JSON array of objects with the fields filename, line, column and
+configs.
+
+If the -refs flag is given, godef prints the location of every
+use of the identifier at offset instead of its definition, one
+per line. All packages in the enclosing module that depend on
+the identifier's package are searched, including their tests.
+With -json, the locations are printed as a single JSON array.
+
+The -impl flag is similar, but prints locations related by interface
+satisfaction: for an interface type, the concrete types that implement
+it; for an interface method, the corresponding concrete methods; for
+a concrete type or method, the interfaces or interface methods it
+implements.
+
+The -callers flag prints the call sites of the function or method
+at offset, searching the same packages as -refs, each followed by
+the name of the calling function. The -callees flag prints the
+declarations of the functions and methods it calls directly, each
+followed by its name. Calls made through an interface method, and
+method values such as x.M in f(x.M), are marked as dynamic; the
+callers of a concrete method include the calls of the interface
+methods it implements. With -json, the calls are printed as a JSON
+array of objects with the fields filename, line, column, name and
+dynamic.
+
+The -complete flag prints the candidates for completing the identifier
+that ends at offset, or that would begin there, one per line with its
+kind and type. After a selector period, the candidates are the fields
+and methods of the expression before it, or the exported names of the
+package it names; otherwise, they are the names in scope at offset,
+including package-level and predeclared ones. Only candidates that
+begin with the part of the identifier already typed are printed. With
+-json, they are printed as a JSON array of objects of the same form as
+members.
+
+The -signature flag prints, for the innermost call whose argument list
+contains offset, the location of the called function's declaration, its
+signature, its parameters one per line with the one receiving the
+argument at offset marked as active, and its doc comment. Builtin
+functions are described by their declarations in package builtin or
+unsafe. With -json, they are printed as a single JSON object with the
+fields filename, line, column, name, signature, params, active (the
+index of the active parameter) and doc.
+
+At most one of -refs, -impl, -callers, -callees, -complete, -signature
+and -all-configs may be given, and at most one of -serve, -batch and
+-lsp.
+
+Godef can run as a server with the -serve flag, listening on the
+Unix socket named by -socket. The server keeps loaded packages in
+memory and reuses them until their files, or the go.mod and go.sum
+files of their module, change; other changes to their dependencies
+are only seen through the overlay. Whenever a server is listening on
+the socket, other invocations of godef forward their flags, working
+directory, standard input and the environment variables that configure
+the go command to it, and print its answer. Setting -socket to the
+empty string disables this. The socket must be in a directory that
+belongs to the user and that no one else can use; by default, it is
+godef/godef.sock in $XDG_RUNTIME_DIR or the user's cache directory.
+
+With the -batch flag, godef reads queries from standard input, one
+JSON object per line, and writes one JSON result per line in the
+same order. A query has the form
+
+	{"id": 1, "file": "a.go", "offset": 120, "expr": ""}
+
+where id is optional and copied to the result, and expr, if given,
+is used instead of offset. A result holds the id, and either the
+position of the definition, the path of an imported package's
+directory, or an error:
+
+	{"id": 1, "position": {"filename": "/src/b.go", "line": 3, "column": 6}}
+
+Packages are loaded only once for all queries on the same package.
+
+With the -lsp flag, godef acts as a minimal language server,
+speaking the Language Server Protocol on its standard input and
+output. It supports the definition, typeDefinition, hover and
+references requests, and answers them using the contents of the
+documents the client has open rather than those saved on disk. The
+-t, -T and -doc flags do not change its answers.
+
 If the -acme flag is given, the offset, file name and contents
 are read from the current acme window.
 
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// docComment returns the doc comment of the declaration of the name at
+// pos, or the empty string if it has none. Neither implementation keeps
+// comments when parsing, so the declaring file is parsed again; if it is
+// filename, its contents are taken from src (when non-nil).
+func docComment(pos Position, filename string, src []byte) string {
+	if pos.Filename == "" || pos.Line < 1 {
+		return ""
+	}
+	var data interface{}
+	if src != nil && newFileCompare(filename)(pos.Filename) {
+		data = src
+	}
+	fset := token.NewFileSet()
+	f, _ := parser.ParseFile(fset, pos.Filename, data, parser.ParseComments)
+	if f == nil {
+		return ""
+	}
+	tfile := fset.File(f.Pos())
+	if pos.Line > tfile.LineCount() {
+		return ""
+	}
+	p := tfile.LineStart(pos.Line) + token.Pos(pos.Column-1)
+	path, _ := astutil.PathEnclosingInterval(f, p, p)
+	if len(path) == 0 {
+		return ""
+	}
+	for i, n := range path {
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			if path[0] != n.Name {
+				return ""
+			}
+			return n.Doc.Text()
+		case *ast.Field:
+			if n.Doc != nil {
+				return n.Doc.Text()
+			}
+			return n.Comment.Text()
+		case *ast.TypeSpec:
+			return specDoc(n.Doc, n.Comment, path[i+1:])
+		case *ast.ValueSpec:
+			return specDoc(n.Doc, n.Comment, path[i+1:])
+		case *ast.ImportSpec:
+			return specDoc(n.Doc, n.Comment, path[i+1:])
+		case *ast.LabeledStmt, *ast.DeclStmt, *ast.Ident:
+			// These can enclose a declaration.
+		case ast.Stmt, *ast.FuncLit:
+			// A declaration inside a function
+			// body that is not a var or const.
+			return ""
+		}
+	}
+	return ""
+}
+
+// specDoc returns the doc comment of a spec in a declaration. A spec in
+// an unparenthesized declaration is documented by the declaration's own
+// doc comment; failing that, a spec's line comment is used.
+func specDoc(doc, comment *ast.CommentGroup, parents []ast.Node) string {
+	if doc != nil {
+		return doc.Text()
+	}
+	if len(parents) > 0 {
+		if decl, ok := parents[0].(*ast.GenDecl); ok && !decl.Lparen.IsValid() && decl.Doc != nil {
+			return decl.Doc.Text()
+		}
+	}
+	return comment.Text()
+}
@@ -6,10 +6,8 @@ toolchain go1.24.0
 
 require (
 	9fans.net/go v0.0.7
+	golang.org/x/mod v0.24.0
 	golang.org/x/tools v0.31.0
 )
 
-require (
-	golang.org/x/mod v0.24.0 // indirect
-	golang.org/x/sync v0.12.0 // indirect
-)
+require golang.org/x/sync v0.12.0 // indirect
@@ -99,6 +99,13 @@ func (obj *Object) Pos() token.Pos {
 				return n.Pos()
 			}
 		}
+		// A method receiver declares its type parameters.
+		_, params := RecvTypeParams(d)
+		for _, n := range params {
+			if n != nil && n.Name == name {
+				return n.Pos()
+			}
+		}
 	case *ImportSpec:
 		if d.Name != nil && d.Name.Name == name {
 			return d.Name.Pos()
@@ -126,6 +133,31 @@ func (obj *Object) Pos() token.Pos {
 	return token.NoPos
 }
 
+// RecvTypeParams returns the type of the method receiver recv, without
+// its type parameters, and the type parameters it declares, as T in
+// func (l *List[T]) Len() int. Parameters that are not identifiers are
+// returned as nil.
+func RecvTypeParams(recv *Field) (Expr, []*Ident) {
+	x := recv.Type
+	if star, ok := x.(*StarExpr); ok {
+		x = star.X
+	}
+	var list []Expr
+	switch t := x.(type) {
+	case *IndexExpr:
+		x, list = t.X, []Expr{t.Index}
+	case *IndexListExpr:
+		x, list = t.X, t.Indices
+	default:
+		return x, nil
+	}
+	params := make([]*Ident, len(list))
+	for i, p := range list {
+		params[i], _ = p.(*Ident)
+	}
+	return x, params
+}
+
 // ObKind describes what an object represents.
 type ObjKind int
 
@@ -167,10 +167,8 @@ func parseFileInPkg(fset *token.FileSet, pkgs map[string]*ast.Package, filename
 		pkg = &ast.Package{name, ast.NewScope(Universe), nil, make(map[string]*ast.File)}
 		pkgs[name] = pkg
 	}
+	// A file with errors is added as far as it could be parsed.
 	src, err = ParseFile(fset, filename, data, mode, pkg.Scope, pathToName)
-	if err != nil {
-		return
-	}
 	pkg.Files[filename] = src
 	return
 }
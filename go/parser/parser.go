@@ -34,6 +34,7 @@ const (
 type parser struct {
 	fset *token.FileSet
 	file *token.File
+	src  []byte
 	scanner.ErrorVector
 	scanner    scanner.Scanner
 	pathToName ImportPathToName
@@ -79,6 +80,7 @@ func scannerMode(mode uint) uint {
 func (p *parser) init(fset *token.FileSet, filename string, src []byte, mode uint, topScope *ast.Scope, pathToName ImportPathToName) {
 	p.fset = fset
 	p.file = fset.AddFile(filename, fset.Base(), len(src))
+	p.src = src
 	p.scanner.Init(p.file, src, p, scannerMode(mode))
 	p.pathToName = pathToName
 	if p.pathToName == nil {
@@ -165,17 +167,19 @@ func (p *parser) declare1(decl ast.Node, scope *ast.Scope, kind ast.ObjKind, ide
 			// methods get declared in the receiver's scope
 			if d, ok := decl.(*ast.FuncDecl); ok && d.Recv != nil {
 				var rt *ast.Object
-				switch t := d.Recv.List[0].Type.(type) {
+				switch t := unindex(deref(d.Recv.List[0].Type)).(type) {
 				case *ast.Ident:
 					rt = t.Obj
-				case *ast.StarExpr:
-					rt = t.X.(*ast.Ident).Obj
 				case *ast.BadExpr:
 					// Partially typed code can get here.
 					return
 				default:
 					panic(fmt.Errorf("unknown type %T (%#v)", t, t))
 				}
+				if rt == nil {
+					// The receiver type is blank.
+					return
+				}
 				if rt.Type == nil {
 					rt.Type = p.newScope(nil)
 				}
@@ -460,8 +464,95 @@ func (p *parser) expect(tok token.Token) token.Pos {
 }
 
 func (p *parser) expectSemi() {
-	if p.tok != token.RPAREN && p.tok != token.RBRACE {
-		p.expect(token.SEMICOLON)
+	if p.tok != token.RPAREN && p.tok != token.RBRACE && !p.atDeclStart() {
+		if p.tok == token.SEMICOLON {
+			p.next()
+			return
+		}
+		p.errorExpected(p.pos, "';'")
+		p.skipStmt()
+	}
+}
+
+// expectBlockEnd is like expect(token.RBRACE) at the end of a block,
+// except that a function declaration, which cannot be inside a block,
+// is taken to end the block without being consumed. So a
+// missing '}' does not swallow the declarations that follow it.
+func (p *parser) expectBlockEnd() token.Pos {
+	if p.atDeclStart() {
+		p.errorExpected(p.pos, "'}'")
+		return p.pos
+	}
+	return p.expect(token.RBRACE)
+}
+
+// atDeclStart reports whether the current token starts a function
+// declaration: a func keyword at the beginning of a line, followed by
+// a name, or by a receiver, a name and a parameter or type parameter
+// list. A function literal, which may also begin a line in a block, is
+// followed by neither. Other declarations may also appear in blocks.
+func (p *parser) atDeclStart() bool {
+	if p.tok != token.FUNC || p.file.Position(p.pos).Column != 1 {
+		return false
+	}
+	// Look ahead with a scanner of its own.
+	src := p.src[p.file.Offset(p.pos)+len("func"):]
+	fset := token.NewFileSet()
+	var s scanner.Scanner
+	s.Init(fset.AddFile("", fset.Base(), len(src)), src, nil, 0)
+	_, tok, _ := s.Scan()
+	if tok == token.IDENT {
+		return true
+	}
+	if tok != token.LPAREN {
+		return false
+	}
+	for depth := 1; depth > 0; {
+		switch _, tok, _ = s.Scan(); tok {
+		case token.LPAREN:
+			depth++
+		case token.RPAREN:
+			depth--
+		case token.EOF:
+			return false
+		}
+	}
+	if _, tok, _ = s.Scan(); tok != token.IDENT {
+		return false
+	}
+	_, tok, _ = s.Scan()
+	return tok == token.LPAREN || tok == token.LBRACK
+}
+
+// skipStmt skips the rest of an erroneous statement: the tokens up to
+// the end of the line, or up to the end of the enclosing block or a
+// function declaration. Braces opened by the skipped tokens are
+// skipped up to their matching close.
+func (p *parser) skipStmt() {
+	line := p.file.Line(p.pos)
+	depth := 0
+	for p.tok != token.EOF && !p.atDeclStart() {
+		switch p.tok {
+		case token.LBRACE:
+			depth++
+		case token.RBRACE:
+			if depth == 0 {
+				return
+			}
+			depth--
+		case token.SEMICOLON:
+			if depth == 0 {
+				p.next()
+				return
+			}
+		default:
+			// A line ending in an operator has no
+			// automatic semicolon.
+			if depth == 0 && p.file.Line(p.pos) > line {
+				return
+			}
+		}
+		p.next()
 	}
 }
 
@@ -569,6 +660,37 @@ func (p *parser) parseTypeName() ast.Expr {
 	return p.parseQualifiedIdent()
 }
 
+// parseTypeInstance parses the type arguments of the generic type x.
+func (p *parser) parseTypeInstance(x ast.Expr) ast.Expr {
+	if p.trace {
+		defer un(trace(p, "TypeInstance"))
+	}
+
+	lbrack := p.expect(token.LBRACK)
+	p.exprLev++
+	list := []ast.Expr{p.parseType()}
+	for p.tok == token.COMMA {
+		p.next()
+		if p.tok == token.RBRACK {
+			break // trailing comma
+		}
+		list = append(list, p.parseType())
+	}
+	p.exprLev--
+	rbrack := p.expect(token.RBRACK)
+
+	return packIndexExpr(x, lbrack, list, rbrack)
+}
+
+// packIndexExpr returns an IndexExpr if there is a single index,
+// and an IndexListExpr otherwise.
+func packIndexExpr(x ast.Expr, lbrack token.Pos, list []ast.Expr, rbrack token.Pos) ast.Expr {
+	if len(list) == 1 {
+		return &ast.IndexExpr{x, lbrack, list[0], rbrack}
+	}
+	return &ast.IndexListExpr{x, lbrack, list, rbrack}
+}
+
 func (p *parser) parseArrayType(ellipsisOk bool) ast.Expr {
 	if p.trace {
 		defer un(trace(p, "ArrayType"))
@@ -633,7 +755,7 @@ func (p *parser) parseFieldDecl() *ast.Field {
 	} else {
 		// ["*"] TypeName (AnonymousField)
 		f.Type = list[0] // we always have at least one element
-		if n := len(list); n > 1 || !isTypeName(deref(f.Type)) {
+		if n := len(list); n > 1 || !isTypeName(unindex(deref(f.Type))) {
 			pos := f.Type.Pos()
 			p.errorExpected(pos, "anonymous field")
 			f.Type = &ast.BadExpr{pos, list[n-1].End()}
@@ -664,6 +786,12 @@ func makeAnonField(t, declType ast.Expr) ast.Expr {
 
 	case *ast.StarExpr:
 		return &ast.StarExpr{t.Star, makeAnonField(t.X, declType)}
+
+	case *ast.IndexExpr:
+		return &ast.IndexExpr{makeAnonField(t.X, declType), t.Lbrack, t.Index, t.Rbrack}
+
+	case *ast.IndexListExpr:
+		return &ast.IndexListExpr{makeAnonField(t.X, declType), t.Lbrack, t.Indices, t.Rbrack}
 	}
 	return t
 }
@@ -733,21 +861,81 @@ func (p *parser) parseVarList(isParam bool) (list []ast.Expr, typ ast.Expr) {
 	// parse/tryVarType accepts any type (including parenthesized
 	// ones) even though the syntax does not permit them here: we
 	// accept them all for more robust parsing and complain later
-	for typ := p.parseVarType(isParam); typ != nil; {
-		list = append(list, typ)
-		if p.tok != token.COMMA {
+	x, typ := p.parseVarElem(isParam)
+	for x != nil {
+		list = append(list, x)
+		if typ != nil || p.tok != token.COMMA {
 			break
 		}
 		p.next()
-		typ = p.tryVarType(isParam) // maybe nil as in: func f(int,) {}
+		x, typ = p.tryVarElem(isParam) // maybe nil as in: func f(int,) {}
 	}
 
 	// if we had a list of identifiers, it must be followed by a type
-	typ = p.tryVarType(isParam)
+	if typ == nil {
+		typ = p.tryVarType(isParam)
+	}
+
+	return
+}
 
+func (p *parser) parseVarElem(isParam bool) (x, typ ast.Expr) {
+	x, typ = p.tryVarElem(isParam)
+	if x == nil {
+		pos := p.pos
+		p.errorExpected(pos, "type")
+		p.next() // make progress
+		x = &ast.BadExpr{pos, p.pos}
+	}
 	return
 }
 
+// tryVarElem parses an element of a variable list. A name followed by
+// "[" may be a generic type, as in T[P], or the name of a variable
+// followed by its array or slice type, as in a [N]T or a []T. In the
+// latter case, tryVarElem returns the type too.
+func (p *parser) tryVarElem(isParam bool) (x, typ ast.Expr) {
+	if p.tok != token.IDENT {
+		return p.tryVarType(isParam), nil
+	}
+	x = p.parseTypeName()
+	if p.tok != token.LBRACK {
+		return x, nil
+	}
+	lbrack := p.expect(token.LBRACK)
+	var list []ast.Expr
+	if p.tok != token.RBRACK {
+		p.exprLev++
+		list = append(list, p.parseExpr())
+		for p.tok == token.COMMA {
+			p.next()
+			if p.tok == token.RBRACK {
+				break // trailing comma
+			}
+			list = append(list, p.parseExpr())
+		}
+		p.exprLev--
+	}
+	rbrack := p.expect(token.RBRACK)
+	if name, isIdent := x.(*ast.Ident); isIdent && len(list) <= 1 {
+		var n, elt ast.Expr
+		if len(list) == 0 {
+			elt = p.parseType()
+		} else {
+			n = list[0]
+			elt = p.tryType()
+		}
+		if elt != nil {
+			return name, &ast.ArrayType{lbrack, n, elt}
+		}
+	}
+	if len(list) == 0 {
+		p.errorExpected(rbrack, "type argument")
+		return &ast.BadExpr{x.Pos(), rbrack + 1}, nil
+	}
+	return packIndexExpr(x, lbrack, list, rbrack), nil
+}
+
 func (p *parser) parseParameterList(scope *ast.Scope, ellipsisOk bool) (params []*ast.Field) {
 	if p.trace {
 		defer un(trace(p, "ParameterList"))
@@ -790,6 +978,78 @@ func (p *parser) parseParameterList(scope *ast.Scope, ellipsisOk bool) (params [
 	return
 }
 
+// parseTypeParams parses a type parameter list whose opening bracket
+// is at lbrack, declaring the type parameters in scope. If name is not
+// nil, it is the first type parameter name, already parsed, as is its
+// constraint if that is not nil.
+func (p *parser) parseTypeParams(scope *ast.Scope, lbrack token.Pos, name *ast.Ident, constraint ast.Expr) *ast.FieldList {
+	if p.trace {
+		defer un(trace(p, "TypeParams"))
+	}
+
+	var list []*ast.Field
+	for p.tok != token.RBRACK && p.tok != token.EOF {
+		var idents []*ast.Ident
+		var typ ast.Expr
+		if name != nil {
+			idents = append(idents, name)
+			typ = constraint
+			name, constraint = nil, nil
+		} else {
+			idents = append(idents, p.parseIdent())
+		}
+		if typ == nil {
+			for p.tok == token.COMMA {
+				p.next()
+				idents = append(idents, p.parseIdent())
+			}
+			typ = p.parseConstraint()
+		}
+		field := &ast.Field{nil, idents, typ, nil, nil}
+		list = append(list, field)
+		// Go spec: The scope of an identifier denoting a type parameter
+		// of a function, or declared by a method receiver, is the
+		// function body; that of a type parameter of a generic type
+		// is the type declaration.
+		p.declare(field, scope, ast.Typ, idents...)
+		if !p.atComma("type parameter list") {
+			break
+		}
+		p.next()
+	}
+	rbrack := p.expect(token.RBRACK)
+
+	return &ast.FieldList{lbrack, list, rbrack}
+}
+
+// parseConstraint parses a type constraint or an embedded interface
+// element: a union of terms, each of which is a type, possibly preceded
+// by "~".
+func (p *parser) parseConstraint() ast.Expr {
+	if p.trace {
+		defer un(trace(p, "Constraint"))
+	}
+
+	x := p.parseConstraintTerm()
+	for p.tok == token.OR {
+		pos := p.pos
+		p.next()
+		y := p.parseConstraintTerm()
+		x = &ast.BinaryExpr{x, pos, token.OR, y}
+	}
+
+	return x
+}
+
+func (p *parser) parseConstraintTerm() ast.Expr {
+	if p.tok == token.TILDE {
+		pos := p.pos
+		p.next()
+		return &ast.UnaryExpr{pos, token.TILDE, p.parseType()}
+	}
+	return p.parseType()
+}
+
 func (p *parser) parseParameters(scope *ast.Scope, ellipsisOk bool) *ast.FieldList {
 	if p.trace {
 		defer un(trace(p, "Parameters"))
@@ -844,7 +1104,7 @@ func (p *parser) parseFuncType() (*ast.FuncType, *ast.Scope) {
 	scope := p.newScope(p.topScope) // function scope
 	params, results := p.parseSignature(scope)
 
-	return &ast.FuncType{pos, params, results}, scope
+	return &ast.FuncType{pos, nil, params, results}, scope
 }
 
 func (p *parser) parseMethodSpec() *ast.Field {
@@ -863,9 +1123,17 @@ func (p *parser) parseMethodSpec() *ast.Field {
 
 		scope := p.newScope(nil) // method scope
 		params, results := p.parseSignature(scope)
-		f.Type = &ast.FuncType{token.NoPos, params, results}
+		f.Type = &ast.FuncType{token.NoPos, nil, params, results}
 	} else {
-		// embedded interface
+		// embedded interface or union
+		if p.tok == token.LBRACK {
+			x = p.parseTypeInstance(x)
+		}
+		for p.tok == token.OR {
+			pos := p.pos
+			p.next()
+			x = &ast.BinaryExpr{x, pos, token.OR, p.parseConstraintTerm()}
+		}
 		f.Type = x
 	}
 	p.expectSemi() // call before accessing p.linecomment
@@ -882,8 +1150,17 @@ func (p *parser) parseInterfaceType() *ast.InterfaceType {
 	pos := p.expect(token.INTERFACE)
 	lbrace := p.expect(token.LBRACE)
 	var list []*ast.Field
-	for p.tok == token.IDENT {
-		list = append(list, p.parseMethodSpec())
+	for p.tok != token.RBRACE && p.tok != token.EOF {
+		if p.tok == token.IDENT {
+			list = append(list, p.parseMethodSpec())
+			continue
+		}
+		// embedded type element, such as ~int | ~string
+		f := &ast.Field{Doc: p.leadComment}
+		f.Type = p.parseConstraint()
+		p.expectSemi() // call before accessing p.linecomment
+		f.Comment = p.lineComment
+		list = append(list, f)
 	}
 	rbrace := p.expect(token.RBRACE)
 
@@ -930,7 +1207,11 @@ func (p *parser) parseChanType() *ast.ChanType {
 func (p *parser) tryRawType(ellipsisOk bool) ast.Expr {
 	switch p.tok {
 	case token.IDENT:
-		return p.parseTypeName()
+		typ := p.parseTypeName()
+		if p.tok == token.LBRACK {
+			typ = p.parseTypeInstance(typ)
+		}
+		return typ
 	case token.LBRACK:
 		return p.parseArrayType(ellipsisOk)
 	case token.STRUCT:
@@ -968,7 +1249,7 @@ func (p *parser) parseStmtList() (list []ast.Stmt) {
 		defer un(trace(p, "StatementList"))
 	}
 
-	for p.tok != token.CASE && p.tok != token.DEFAULT && p.tok != token.RBRACE && p.tok != token.EOF {
+	for p.tok != token.CASE && p.tok != token.DEFAULT && p.tok != token.RBRACE && p.tok != token.EOF && !p.atDeclStart() {
 		list = append(list, p.parseStmt())
 	}
 
@@ -986,7 +1267,7 @@ func (p *parser) parseBody(scope *ast.Scope) *ast.BlockStmt {
 	list := p.parseStmtList()
 	p.closeLabelScope()
 	p.closeScope()
-	rbrace := p.expect(token.RBRACE)
+	rbrace := p.expectBlockEnd()
 
 	return &ast.BlockStmt{lbrace, list, rbrace}
 }
@@ -1000,7 +1281,7 @@ func (p *parser) parseBlockStmt() *ast.BlockStmt {
 	p.openScope()
 	list := p.parseStmtList()
 	p.closeScope()
-	rbrace := p.expect(token.RBRACE)
+	rbrace := p.expectBlockEnd()
 
 	return &ast.BlockStmt{lbrace, list, rbrace}
 }
@@ -1106,6 +1387,20 @@ func (p *parser) parseIndexOrSlice(x ast.Expr) ast.Expr {
 	if p.tok != token.COLON {
 		index[0] = p.parseExpr()
 	}
+	if index[0] != nil && p.tok == token.COMMA {
+		// instantiation with several type arguments
+		list := []ast.Expr{index[0]}
+		for p.tok == token.COMMA {
+			p.next()
+			if p.tok == token.RBRACK {
+				break // trailing comma
+			}
+			list = append(list, p.parseType())
+		}
+		p.exprLev--
+		rbrack := p.expect(token.RBRACK)
+		return &ast.IndexListExpr{x, lbrack, list, rbrack}
+	}
 	ncolons := 0
 	for p.tok == token.COLON && ncolons < len(index)-1 {
 		p.next()
@@ -1213,6 +1508,7 @@ func (p *parser) checkExpr(x ast.Expr) ast.Expr {
 		panic("unreachable")
 	case *ast.SelectorExpr:
 	case *ast.IndexExpr:
+	case *ast.IndexListExpr:
 	case *ast.SliceExpr:
 	case *ast.TypeAssertExpr:
 		if t.Type == nil {
@@ -1259,6 +1555,10 @@ func isLiteralType(x ast.Expr) bool {
 	case *ast.SelectorExpr:
 		_, isIdent := t.X.(*ast.Ident)
 		return isIdent
+	case *ast.IndexExpr:
+		return isTypeName(t.X)
+	case *ast.IndexListExpr:
+		return isTypeName(t.X)
 	case *ast.ArrayType:
 	case *ast.StructType:
 	case *ast.MapType:
@@ -1276,6 +1576,17 @@ func deref(x ast.Expr) ast.Expr {
 	return x
 }
 
+// If x is of the form T[P] or T[P1, P2], unindex returns T, otherwise it returns x.
+func unindex(x ast.Expr) ast.Expr {
+	switch t := x.(type) {
+	case *ast.IndexExpr:
+		x = t.X
+	case *ast.IndexListExpr:
+		x = t.X
+	}
+	return x
+}
+
 // If x is of the form (T), unparen returns unparen(T), otherwise it returns x.
 func unparen(x ast.Expr) ast.Expr {
 	if p, isParen := x.(*ast.ParenExpr); isParen {
@@ -1307,12 +1618,16 @@ func (p *parser) checkExprOrType(x ast.Expr) ast.Expr {
 	return x
 }
 
-func (p *parser) parsePrimaryExpr() ast.Expr {
+// parsePrimaryExpr parses a primary expression. If x is not nil,
+// it is the operand, already parsed.
+func (p *parser) parsePrimaryExpr(x ast.Expr) ast.Expr {
 	if p.trace {
 		defer un(trace(p, "PrimaryExpr"))
 	}
 
-	x := p.parseOperand()
+	if x == nil {
+		x = p.parseOperand()
+	}
 L:
 	for {
 		switch p.tok {
@@ -1323,7 +1638,7 @@ L:
 		case token.LPAREN:
 			x = p.parseCallOrConversion(p.checkExprOrType(x))
 		case token.LBRACE:
-			if isLiteralType(x) && (p.exprLev >= 0 || !isTypeName(x)) {
+			if isLiteralType(x) && (p.exprLev >= 0 || !isTypeName(unindex(x))) {
 				x = p.parseLiteralValue(x)
 			} else {
 				break L
@@ -1369,20 +1684,24 @@ func (p *parser) parseUnaryExpr() ast.Expr {
 		return &ast.StarExpr{pos, p.checkExprOrType(x)}
 	}
 
-	return p.parsePrimaryExpr()
+	return p.parsePrimaryExpr(nil)
 }
 
-func (p *parser) parseBinaryExpr(prec1 int) ast.Expr {
+// parseBinaryExpr parses a binary expression. If x is not nil,
+// it is the left-most operand, already parsed.
+func (p *parser) parseBinaryExpr(x ast.Expr, prec1 int) ast.Expr {
 	if p.trace {
 		defer un(trace(p, "BinaryExpr"))
 	}
 
-	x := p.parseUnaryExpr()
+	if x == nil {
+		x = p.parseUnaryExpr()
+	}
 	for prec := p.tok.Precedence(); prec >= prec1; prec-- {
 		for p.tok.Precedence() == prec {
 			pos, op := p.pos, p.tok
 			p.next()
-			y := p.parseBinaryExpr(prec + 1)
+			y := p.parseBinaryExpr(nil, prec+1)
 			x = &ast.BinaryExpr{p.checkExpr(x), pos, op, p.checkExpr(y)}
 		}
 	}
@@ -1398,7 +1717,7 @@ func (p *parser) parseExpr() ast.Expr {
 		defer un(trace(p, "Expression"))
 	}
 
-	return p.parseBinaryExpr(token.LowestPrec + 1)
+	return p.parseBinaryExpr(nil, token.LowestPrec+1)
 }
 
 // ----------------------------------------------------------------------------
@@ -1681,7 +2000,7 @@ func (p *parser) parseSwitchStmt() ast.Stmt {
 	for p.tok == token.CASE || p.tok == token.DEFAULT {
 		list = append(list, p.parseCaseClause(exprSwitch))
 	}
-	rbrace := p.expect(token.RBRACE)
+	rbrace := p.expectBlockEnd()
 	p.expectSemi()
 	body := &ast.BlockStmt{lbrace, list, rbrace}
 
@@ -1690,18 +2009,12 @@ func (p *parser) parseSwitchStmt() ast.Stmt {
 	}
 	stmt := &ast.TypeSwitchStmt{pos, s1, s2, body}
 	if p.topScope != nil {
-		if s2, ok := s2.(*ast.AssignStmt); ok && s2.Tok == token.DEFINE {
-			if len(s2.Lhs) != 1 {
-				panic("bad len")
-			}
-			if _, ok := s2.Lhs[0].(*ast.Ident); !ok {
-				panic("not an identifier")
+		// Erroneous code may have several or blank
+		// identifiers, or none, on the left.
+		if s2, ok := s2.(*ast.AssignStmt); ok && s2.Tok == token.DEFINE && len(s2.Lhs) == 1 {
+			if id, ok := s2.Lhs[0].(*ast.Ident); ok && id.Obj != nil {
+				id.Obj.Decl = stmt
 			}
-			if s2.Lhs[0].(*ast.Ident).Obj == nil {
-				panic(fmt.Sprintf("no object for %v(%p)", s2.Lhs[0], s2.Lhs[0]))
-			}
-			// TODO is this guarded sufficiently well?
-			s2.Lhs[0].(*ast.Ident).Obj.Decl = stmt
 		}
 	}
 	// type switch
@@ -1791,7 +2104,7 @@ func (p *parser) parseSelectStmt() *ast.SelectStmt {
 	for p.tok == token.CASE || p.tok == token.DEFAULT {
 		list = append(list, p.parseCommClause())
 	}
-	rbrace := p.expect(token.RBRACE)
+	rbrace := p.expectBlockEnd()
 	p.expectSemi()
 	body := &ast.BlockStmt{lbrace, list, rbrace}
 
@@ -1928,7 +2241,7 @@ func (p *parser) parseStmt() (s ast.Stmt) {
 		// no statement found
 		pos := p.pos
 		p.errorExpected(pos, "statement")
-		p.next() // make progress
+		p.skipStmt() // make progress
 		s = &ast.BadStmt{pos, p.pos}
 	}
 
@@ -1938,6 +2251,15 @@ func (p *parser) parseStmt() (s ast.Stmt) {
 // ----------------------------------------------------------------------------
 // Declarations
 
+// declStart holds the keywords that start a top-level declaration.
+var declStart = map[token.Token]bool{
+	token.IMPORT: true,
+	token.CONST:  true,
+	token.TYPE:   true,
+	token.VAR:    true,
+	token.FUNC:   true,
+}
+
 type parseSpecFunction func(p *parser, doc *ast.CommentGroup, decl *ast.GenDecl, iota int) ast.Spec
 
 func parseImportSpec(p *parser, doc *ast.CommentGroup, decl *ast.GenDecl, _ int) ast.Spec {
@@ -2021,19 +2343,85 @@ func parseTypeSpec(p *parser, doc *ast.CommentGroup, decl *ast.GenDecl, _ int) a
 	// at the identifier in the TypeSpec and ends at the end of the innermost
 	// containing block.
 	// (Global identifiers are resolved in a separate phase after parsing.)
-	spec := &ast.TypeSpec{doc, ident, token.NoPos, nil, p.lineComment}
+	spec := &ast.TypeSpec{doc, ident, nil, token.NoPos, nil, p.lineComment}
 	p.declare(spec, p.topScope, ast.Typ, ident)
-	if p.tok == token.ASSIGN {
-		spec.Assign = p.pos
+	var typ ast.Expr
+	if p.tok == token.LBRACK {
+		// type parameter list or array type
+		lbrack := p.pos
 		p.next()
+		var len ast.Expr
+		if p.tok == token.IDENT {
+			x := p.parseIdent()
+			if isTypeParamStart(p.tok) {
+				p.openScope()
+				defer p.closeScope()
+				spec.TypeParams = p.parseTypeParams(p.topScope, lbrack, x, nil)
+			} else {
+				p.resolve(x)
+				p.exprLev++
+				len = p.parseBinaryExpr(p.parsePrimaryExpr(x), token.LowestPrec+1)
+				p.exprLev--
+				// An expression such as P *C | D followed by
+				// a comma starts a type parameter list too.
+				if name, constraint := splitTypeParam(len); name != nil && p.tok == token.COMMA {
+					p.openScope()
+					defer p.closeScope()
+					spec.TypeParams = p.parseTypeParams(p.topScope, lbrack, name, constraint)
+				}
+			}
+		} else if p.tok != token.RBRACK {
+			len = p.parseExpr()
+		}
+		if spec.TypeParams == nil {
+			p.expect(token.RBRACK)
+			typ = &ast.ArrayType{lbrack, len, p.parseType()}
+		}
+	}
+	if typ == nil {
+		if p.tok == token.ASSIGN {
+			spec.Assign = p.pos
+			p.next()
+		}
+		typ = p.parseType()
 	}
-	typ := p.parseType()
 	p.expectSemi() // call before accessing p.linecomment
 	spec.Type = typ
 
 	return spec
 }
 
+// splitTypeParam splits x, parsed as the length of an array type, into
+// a type parameter name and its constraint if x has the form P *C,
+// possibly followed by further terms of a union, as in P *C | D.
+func splitTypeParam(x ast.Expr) (*ast.Ident, ast.Expr) {
+	if t, isBinary := x.(*ast.BinaryExpr); isBinary {
+		switch t.Op {
+		case token.MUL:
+			if name, isIdent := t.X.(*ast.Ident); isIdent {
+				return name, &ast.StarExpr{t.OpPos, t.Y}
+			}
+		case token.OR:
+			if name, lhs := splitTypeParam(t.X); name != nil {
+				return name, &ast.BinaryExpr{lhs, t.OpPos, t.Op, t.Y}
+			}
+		}
+	}
+	return nil, nil
+}
+
+// isTypeParamStart reports whether tok, following "[" and a name at the
+// start of a type declaration, begins the constraint of a type parameter
+// rather than continuing the length of an array type.
+func isTypeParamStart(tok token.Token) bool {
+	switch tok {
+	case token.IDENT, token.COMMA, token.TILDE, token.LBRACK, token.INTERFACE,
+		token.FUNC, token.MAP, token.CHAN, token.STRUCT, token.ARROW:
+		return true
+	}
+	return false
+}
+
 func parseVarSpec(p *parser, doc *ast.CommentGroup, decl *ast.GenDecl, _ int) ast.Spec {
 	if p.trace {
 		defer un(trace(p, "VarSpec"))
@@ -2111,9 +2499,10 @@ func (p *parser) parseReceiver(scope *ast.Scope) *ast.FieldList {
 		return par
 	}
 
-	// recv type must be of the form ["*"] identifier
+	// recv type must be of the form ["*"] identifier, possibly
+	// followed by type parameters
 	recv := par.List[0]
-	base := deref(recv.Type)
+	base := unindex(deref(recv.Type))
 	if _, isIdent := base.(*ast.Ident); !isIdent {
 		p.errorExpected(base.Pos(), "(unqualified) identifier")
 		par.List = []*ast.Field{&ast.Field{Type: &ast.BadExpr{recv.Pos(), recv.End()}}}
@@ -2122,6 +2511,49 @@ func (p *parser) parseReceiver(scope *ast.Scope) *ast.FieldList {
 	return par
 }
 
+// declareRecvTypeParams declares in scope the type parameters named
+// by the receiver that starts at the current token, as T in
+// func (l *List[T]) Len() int. They are declared before the receiver
+// is parsed, so that the receiver type refers to them rather than to
+// package-level types of the same names. It returns their objects,
+// whose declaration is left to be set to the receiver field.
+func (p *parser) declareRecvTypeParams(scope *ast.Scope) []*ast.Object {
+	if scope == nil {
+		return nil
+	}
+	// Look ahead with a scanner of its own.
+	src := p.src[p.file.Offset(p.pos)+len("("):]
+	fset := token.NewFileSet()
+	var s scanner.Scanner
+	s.Init(fset.AddFile("", fset.Base(), len(src)), src, nil, 0)
+	var objs []*ast.Object
+	prev := token.LPAREN
+	for parens, bracks := 1, 0; parens > 0; {
+		_, tok, lit := s.Scan()
+		switch tok {
+		case token.EOF:
+			return objs
+		case token.LPAREN:
+			parens++
+		case token.RPAREN:
+			parens--
+		case token.LBRACK:
+			bracks++
+		case token.RBRACK:
+			bracks--
+		case token.IDENT:
+			if parens == 1 && bracks == 1 && (prev == token.LBRACK || prev == token.COMMA) && lit != "_" {
+				obj := ast.NewObj(ast.Typ, lit)
+				if scope.Insert(obj) == nil {
+					objs = append(objs, obj)
+				}
+			}
+		}
+		prev = tok
+	}
+	return objs
+}
+
 func (p *parser) parseFuncDecl() *ast.FuncDecl {
 	if p.trace {
 		defer un(trace(p, "FunctionDecl"))
@@ -2129,24 +2561,40 @@ func (p *parser) parseFuncDecl() *ast.FuncDecl {
 
 	doc := p.leadComment
 	pos := p.expect(token.FUNC)
-	scope := p.newScope(p.topScope) // function scope
+	outer := p.topScope
+	tscope := p.newScope(outer) // type parameter scope
+	scope := p.newScope(tscope) // function scope
+
+	// The type parameters are in scope in the receiver,
+	// the signature and the body.
+	p.topScope = tscope
 
 	var recv *ast.FieldList
 	if p.tok == token.LPAREN {
+		tparamObjs := p.declareRecvTypeParams(tscope)
 		recv = p.parseReceiver(scope)
+		for _, obj := range tparamObjs {
+			obj.Decl = recv.List[0]
+		}
 	}
 
 	ident := p.parseIdent()
 
+	var tparams *ast.FieldList
+	if p.tok == token.LBRACK {
+		tparams = p.parseTypeParams(tscope, p.expect(token.LBRACK), nil, nil)
+	}
+
 	params, results := p.parseSignature(scope)
 
 	var body *ast.BlockStmt
 	if p.tok == token.LBRACE {
 		body = p.parseBody(scope)
 	}
+	p.topScope = outer
 	p.expectSemi()
 
-	decl := &ast.FuncDecl{doc, recv, ident, &ast.FuncType{pos, params, results}, body}
+	decl := &ast.FuncDecl{doc, recv, ident, &ast.FuncType{pos, tparams, params, results}, body}
 	// Go spec: The scope of an identifier denoting a constant, type,
 	// variable, or function (but not method) declared at top level
 	// (outside any function) is the package block.
@@ -2183,6 +2631,10 @@ func (p *parser) parseDecl() ast.Decl {
 		pos := p.pos
 		p.errorExpected(pos, "declaration")
 		p.next() // make progress
+		// skip to the next declaration
+		for p.tok != token.EOF && !declStart[p.tok] {
+			p.next()
+		}
 		decl := &ast.BadDecl{pos, p.pos}
 		return decl
 	}
@@ -5,9 +5,11 @@
 package parser
 
 import (
+	"fmt"
 	"os"
 	"testing"
 
+	"github.com/bobg/godef/go/ast"
 	"github.com/bobg/godef/go/token"
 )
 
@@ -53,6 +55,23 @@ var validPrograms = []interface{}{
 	`package p; func f() { switch ; {} };`,
 	`package p; func f() (int,) {}`,
 	`package p; func _(x []int) { for range x {} }`,
+	`package p; type T[P any] struct{ p P }`,
+	`package p; type T[P1, P2 any, P3 comparable] []P1`,
+	`package p; type T[P *C, Q *C | []int] struct{}`,
+	`package p; type T [N]int; type U [N * 2]int; type V [len(x)]int`,
+	`package p; type C interface{ ~int | ~string; String() string }`,
+	`package p; type C[T any] interface{ E[T]; M(T) }`,
+	`package p; func f[T any, S ~[]T](s S) T { var t T; return t }`,
+	`package p; func (l *List[T]) Len() int { return 0 }`,
+	`package p; func (m Map[K, V]) Get(k K) V { return m.m[k] }`,
+	`package p; func f(a, b [2]int, c []int) {}`,
+	`package p; type T struct { a [2]int; b []int; List[int]; *p.Set[int, string] }`,
+	`package p; var x = f[int, string](1)`,
+	`package p; var x = List[int]{}; var y = Map[string, int]{}`,
+	`package p; func f() { if x := (T[int]{}); x.ok {} }`,
+	`package p; func f() { for _, x := range m[k] {} }`,
+	`package p; const c = 0b1010 + 0o755 + 0O7 + 1_000_000 + 0x_cafe; var f = 0x1p-2 + 1_0.2_5e1_0 + 0b1i`,
+	"package p\nfunc f() {\nfunc() {}()\nfunc(x int) (y int) { return x }(1)\nfunc (x int) {}(2)\n}\n",
 }
 
 func TestParseValidPrograms(t *testing.T) {
@@ -64,6 +83,125 @@ func TestParseValidPrograms(t *testing.T) {
 	}
 }
 
+func TestTypeParamScopes(t *testing.T) {
+	const src = `package p
+
+type List[T any] struct {
+	next *List[T]
+	val  T
+}
+
+func (l *List[E]) Push(v E) {}
+
+func Map[S, R any](s []S, f func(S) R) []R { var r R; return []R{r} }
+`
+	f, err := ParseFile(fset, "", src, 0, ast.NewScope(Universe), naiveImportPathToName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every use of a type parameter must refer to its declaration.
+	decls := make(map[string]*ast.Object)
+	ast.Inspect(f, func(n ast.Node) bool {
+		id, ok := n.(*ast.Ident)
+		if !ok || len(id.Name) != 1 || !id.IsExported() {
+			return true
+		}
+		if id.Obj == nil || id.Obj.Kind != ast.Typ {
+			t.Errorf("%s at %s: got object %v, want type parameter", id.Name, fset.Position(id.Pos()), id.Obj)
+			return true
+		}
+		if _, isField := id.Obj.Decl.(*ast.Field); !isField {
+			t.Errorf("%s at %s: declared by %T, want *ast.Field", id.Name, fset.Position(id.Pos()), id.Obj.Decl)
+		}
+		if prev := decls[id.Name]; prev != nil && prev != id.Obj {
+			t.Errorf("%s at %s: refers to a different object", id.Name, fset.Position(id.Pos()))
+		}
+		decls[id.Name] = id.Obj
+		return true
+	})
+	if len(decls) != 4 {
+		t.Errorf("got type parameters %v, want T, E, S and R", decls)
+	}
+	if obj := f.Scope.Lookup("T"); obj != nil {
+		t.Errorf("type parameter T leaked into the file scope")
+	}
+}
+
+func TestRecvTypeParamShadows(t *testing.T) {
+	const src = `package p
+
+type E int
+
+func (l *List[E, F]) Push(v E, w F) {}
+
+type List[T, U any] struct{}
+`
+	pkgScope := ast.NewScope(Universe)
+	f, err := ParseFile(fset, "", src, 0, pkgScope, naiveImportPathToName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recv := f.Decls[1].(*ast.FuncDecl).Recv.List[0]
+	ast.Inspect(f.Decls[1], func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && (id.Name == "E" || id.Name == "F") {
+			if id.Obj == nil || id.Obj.Decl != recv {
+				t.Errorf("%s at %s: got object %v, want the receiver type parameter", id.Name, fset.Position(id.Pos()), id.Obj)
+			}
+		}
+		return true
+	})
+	if obj := pkgScope.Lookup("F"); obj != nil {
+		t.Errorf("type parameter F leaked into the package scope")
+	}
+}
+
+func TestParseRecovery(t *testing.T) {
+	const src = `package p
+
+func unclosed() {
+	if x {
+		y = 1
+
+func garbled() {
+	z := 1 + * ) ]
+	_ = z
+}
+
+var after int
+`
+	f, err := ParseFile(fset, "", src, 0, ast.NewScope(Universe), naiveImportPathToName)
+	if err == nil {
+		t.Errorf("ParseFile should have failed")
+	}
+	if f == nil {
+		t.Fatal("no file returned")
+	}
+	// The declarations after each error must still be found.
+	var names []string
+	for _, d := range f.Decls {
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			names = append(names, d.Name.Name)
+		case *ast.GenDecl:
+			for _, s := range d.Specs {
+				if vs, ok := s.(*ast.ValueSpec); ok {
+					names = append(names, vs.Names[0].Name)
+				}
+			}
+		}
+	}
+	if got, want := fmt.Sprint(names), "[unclosed garbled after]"; got != want {
+		t.Errorf("got declarations %s, want %s", got, want)
+	}
+	// Identifiers following a bad statement are still resolved.
+	ast.Inspect(f, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && id.Name == "z" && id.Obj == nil {
+			t.Errorf("z at %s is not resolved", fset.Position(id.Pos()))
+		}
+		return true
+	})
+}
+
 var validFiles = []string{
 	"parser.go",
 	"parser_test.go",
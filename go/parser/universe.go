@@ -4,9 +4,56 @@ import "github.com/bobg/godef/go/ast"
 
 var Universe = ast.NewScope(nil)
 
-func declObj(kind ast.ObjKind, name string) {
+// aliases holds the predeclared aliases.
+var aliases = make(map[*ast.Object]bool)
+
+// IsUniverseAlias reports whether obj is a predeclared alias, such as any.
+func IsUniverseAlias(obj *ast.Object) bool {
+	return aliases[obj]
+}
+
+// UniverseAliasOf returns the predeclared alias whose declared type
+// is typ, or nil if there is none.
+func UniverseAliasOf(typ ast.Expr) *ast.Object {
+	for obj := range aliases {
+		if obj.Decl.(*ast.TypeSpec).Type == typ {
+			return obj
+		}
+	}
+	return nil
+}
+
+func declObj(kind ast.ObjKind, name string) *ast.Object {
 	// don't use Insert because it forbids adding to Universe
-	Universe.Objects[name] = ast.NewObj(kind, name)
+	obj := ast.NewObj(kind, name)
+	Universe.Objects[name] = obj
+	return obj
+}
+
+// declAlias declares name as an alias for the type typ.
+// Having no source, it has no position.
+func declAlias(name string, typ ast.Expr) {
+	obj := declObj(ast.Typ, name)
+	obj.Decl = &ast.TypeSpec{Name: &ast.Ident{Name: name, Obj: obj}, Type: typ}
+	aliases[obj] = true
+}
+
+// declError declares the error interface, whose Error method,
+// like the interface itself, has no position.
+func declError() {
+	obj := declObj(ast.Typ, "error")
+	method := &ast.Field{Names: []*ast.Ident{{Name: "Error", Obj: ast.NewObj(ast.Fun, "Error")}}}
+	method.Names[0].Obj.Decl = method
+	method.Type = &ast.FuncType{
+		Params: &ast.FieldList{},
+		Results: &ast.FieldList{List: []*ast.Field{{
+			Type: &ast.Ident{Name: "string", Obj: Universe.Lookup("string")},
+		}}},
+	}
+	obj.Decl = &ast.TypeSpec{
+		Name: &ast.Ident{Name: "error", Obj: obj},
+		Type: &ast.InterfaceType{Methods: &ast.FieldList{List: []*ast.Field{method}}},
+	}
 }
 
 func init() {
@@ -28,12 +75,14 @@ func init() {
 	declObj(ast.Typ, "uint32")
 	declObj(ast.Typ, "uint64")
 
-	declObj(ast.Typ, "float")
 	declObj(ast.Typ, "float32")
 	declObj(ast.Typ, "float64")
 
 	declObj(ast.Typ, "string")
-	declObj(ast.Typ, "error")
+	declError()
+	declObj(ast.Typ, "comparable")
+
+	declAlias("any", &ast.InterfaceType{Methods: &ast.FieldList{}})
 
 	// predeclared constants
 	// TODO(gri) provide constant value
@@ -46,6 +95,7 @@ func init() {
 	// TODO(gri) provide "type"
 	declObj(ast.Fun, "append")
 	declObj(ast.Fun, "cap")
+	declObj(ast.Fun, "clear")
 	declObj(ast.Fun, "close")
 	declObj(ast.Fun, "complex")
 	declObj(ast.Fun, "copy")
@@ -53,9 +103,10 @@ func init() {
 	declObj(ast.Fun, "imag")
 	declObj(ast.Fun, "len")
 	declObj(ast.Fun, "make")
+	declObj(ast.Fun, "max")
+	declObj(ast.Fun, "min")
 	declObj(ast.Fun, "new")
 	declObj(ast.Fun, "panic")
-	declObj(ast.Fun, "panicln")
 	declObj(ast.Fun, "print")
 	declObj(ast.Fun, "println")
 	declObj(ast.Fun, "real")
@@ -67,5 +118,5 @@ func init() {
 	Universe.Objects["byte"] = Universe.Objects["uint8"]
 
 	// The same applies to rune.
-	Universe.Objects["rune"] = Universe.Objects["uint32"]
+	Universe.Objects["rune"] = Universe.Objects["int32"]
 }
@@ -269,7 +269,16 @@ func (p *printer) exprList(prev0 token.Pos, list []ast.Expr, depth int, mode exp
 
 // Sets multiLine to true if the the parameter list spans multiple lines.
 func (p *printer) parameters(fields *ast.FieldList, multiLine *bool) {
-	p.print(fields.Opening, token.LPAREN)
+	p.paramList(fields, token.LPAREN, token.RPAREN, multiLine)
+}
+
+// Sets multiLine to true if the the type parameter list spans multiple lines.
+func (p *printer) typeParams(fields *ast.FieldList, multiLine *bool) {
+	p.paramList(fields, token.LBRACK, token.RBRACK, multiLine)
+}
+
+func (p *printer) paramList(fields *ast.FieldList, open, close token.Token, multiLine *bool) {
+	p.print(fields.Opening, open)
 	if len(fields.List) > 0 {
 		var prevLine, line int
 		for i, par := range fields.List {
@@ -294,7 +303,7 @@ func (p *printer) parameters(fields *ast.FieldList, multiLine *bool) {
 			prevLine = p.fset.Position(par.Type.Pos()).Line
 		}
 	}
-	p.print(fields.Closing, token.RPAREN)
+	p.print(fields.Closing, close)
 }
 
 // Sets multiLine to true if the signature spans multiple lines.
@@ -352,6 +361,12 @@ func (p *printer) fieldList(fields *ast.FieldList, isStruct, isIncomplete bool)
 	list := fields.List
 	rbrace := fields.Closing
 	srcIsOneLine := lbrace.IsValid() && rbrace.IsValid() && p.fset.Position(lbrace).Line == p.fset.Position(rbrace).Line
+	if !lbrace.IsValid() && !rbrace.IsValid() && len(list) == 0 && !isIncomplete {
+		// an empty struct/interface with no source, such as
+		// that of the predeclared any: print it on one line
+		p.print(token.LBRACE, token.RBRACE)
+		return
+	}
 
 	if !isIncomplete && !p.commentBefore(p.fset.Position(rbrace)) && srcIsOneLine {
 		// possibly a one-line struct/interface
@@ -656,6 +671,12 @@ func splitSelector(expr ast.Expr) (body, suffix ast.Expr) {
 			suffix = &ast.IndexExpr{suffix, x.Lbrack, x.Index, x.Rbrack}
 			return
 		}
+	case *ast.IndexListExpr:
+		body, suffix = splitSelector(x.X)
+		if body != nil {
+			suffix = &ast.IndexListExpr{suffix, x.Lbrack, x.Indices, x.Rbrack}
+			return
+		}
 	case *ast.SliceExpr:
 		body, suffix = splitSelector(x.X)
 		if body != nil {
@@ -784,6 +805,12 @@ func (p *printer) expr1(expr ast.Expr, prec1, depth int, multiLine *bool) {
 		p.expr0(x.Index, depth+1, multiLine)
 		p.print(x.Rbrack, token.RBRACK)
 
+	case *ast.IndexListExpr:
+		p.expr1(x.X, token.HighestPrec, 1, multiLine)
+		p.print(x.Lbrack, token.LBRACK)
+		p.exprList(x.Lbrack, x.Indices, depth+1, commaSep, multiLine, x.Rbrack)
+		p.print(x.Rbrack, token.RBRACK)
+
 	case *ast.SliceExpr:
 		// TODO(gri): should treat[] like parentheses and undo one level of depth
 		p.expr1(x.X, token.HighestPrec, 1, multiLine)
@@ -1292,6 +1319,9 @@ func (p *printer) spec(spec ast.Spec, n int, doIndent bool, multiLine *bool) {
 	case *ast.TypeSpec:
 		p.setComment(s.Doc)
 		p.expr(s.Name, multiLine)
+		if s.TypeParams != nil {
+			p.typeParams(s.TypeParams, multiLine)
+		}
 		if n == 1 {
 			p.print(blank)
 		} else {
@@ -1458,6 +1488,9 @@ func (p *printer) funcDecl(d *ast.FuncDecl, multiLine *bool) {
 		p.print(blank)
 	}
 	p.expr(d.Name, multiLine)
+	if d.Type.TypeParams != nil {
+		p.typeParams(d.Type.TypeParams, multiLine)
+	}
 	p.signature(d.Type.Params, d.Type.Results, multiLine)
 	p.funcBody(d.Body, p.distance(d.Pos(), p.pos), false, multiLine)
 }
@@ -257,83 +257,186 @@ func digitVal(ch rune) int {
 	return 16 // larger than any legal digit val
 }
 
-func (S *Scanner) scanMantissa(base int) {
-	for digitVal(S.ch) < base {
-		S.next()
+func lower(ch rune) rune     { return ('a' - 'A') | ch }
+func isDecimal(ch rune) bool { return '0' <= ch && ch <= '9' }
+func isHex(ch rune) bool     { return '0' <= ch && ch <= '9' || 'a' <= lower(ch) && lower(ch) <= 'f' }
+
+// digits accepts the sequence { digit | '_' }.
+// If base <= 10, digits accepts any decimal digit but records
+// the offset of the first digit >= base in *invalid, if *invalid < 0.
+// digits returns a bitset describing whether the sequence contained
+// digits (bit 0 is set), or separators '_' (bit 1 is set).
+func (S *Scanner) digits(base int, invalid *int) (digsep int) {
+	if base <= 10 {
+		max := rune('0' + base)
+		for isDecimal(S.ch) || S.ch == '_' {
+			ds := 1
+			if S.ch == '_' {
+				ds = 2
+			} else if S.ch >= max && *invalid < 0 {
+				*invalid = S.offset
+			}
+			digsep |= ds
+			S.next()
+		}
+	} else {
+		for isHex(S.ch) || S.ch == '_' {
+			ds := 1
+			if S.ch == '_' {
+				ds = 2
+			}
+			digsep |= ds
+			S.next()
+		}
 	}
+	return
 }
 
+// scanNumber scans a number literal: a decimal, hexadecimal, octal or
+// binary integer, a decimal or hexadecimal floating-point number, or
+// an imaginary number, any of which may contain '_' separators. If
+// seenDecimalPoint is true, the leading '.' of a fraction has already
+// been consumed.
 func (S *Scanner) scanNumber(seenDecimalPoint bool) token.Token {
-	// digitVal(S.ch) < 10
+	offs := S.offset
 	tok := token.INT
 
+	base := 10        // number base
+	prefix := rune(0) // one of 0 (decimal), '0' (0-octal), 'x', 'o', or 'b'
+	digsep := 0       // bit 0: digit present, bit 1: '_' present
+	invalid := -1     // offset of invalid digit in literal, or < 0
+
 	if seenDecimalPoint {
+		offs-- // include the '.'
 		tok = token.FLOAT
-		S.scanMantissa(10)
-		goto exponent
-	}
-
-	if S.ch == '0' {
-		// int or float
-		offs := S.offset
-		S.next()
-		if S.ch == 'x' || S.ch == 'X' {
-			// hexadecimal int
+		digsep |= S.digits(base, &invalid)
+	} else {
+		// integer part
+		if S.ch == '0' {
 			S.next()
-			S.scanMantissa(16)
-			if S.offset-offs <= 2 {
-				// only scanned "0x" or "0X"
-				S.error(offs, "illegal hexadecimal number")
-			}
-		} else {
-			// octal int or float
-			seenDecimalDigit := false
-			S.scanMantissa(8)
-			if S.ch == '8' || S.ch == '9' {
-				// illegal octal int or float
-				seenDecimalDigit = true
-				S.scanMantissa(10)
-			}
-			if S.ch == '.' || S.ch == 'e' || S.ch == 'E' || S.ch == 'i' {
-				goto fraction
+			switch lower(S.ch) {
+			case 'x':
+				S.next()
+				base, prefix = 16, 'x'
+			case 'o':
+				S.next()
+				base, prefix = 8, 'o'
+			case 'b':
+				S.next()
+				base, prefix = 2, 'b'
+			default:
+				base, prefix = 8, '0'
+				digsep = 1 // leading 0
 			}
-			// octal int
-			if seenDecimalDigit {
-				S.error(offs, "illegal octal number")
+		}
+		digsep |= S.digits(base, &invalid)
+
+		// fractional part
+		if S.ch == '.' {
+			tok = token.FLOAT
+			if prefix == 'o' || prefix == 'b' {
+				S.error(S.offset, "invalid radix point in "+litname(prefix))
 			}
+			S.next()
+			digsep |= S.digits(base, &invalid)
 		}
-		goto exit
 	}
 
-	// decimal int or float
-	S.scanMantissa(10)
-
-fraction:
-	if S.ch == '.' {
-		tok = token.FLOAT
-		S.next()
-		S.scanMantissa(10)
+	if digsep&1 == 0 {
+		S.error(S.offset, litname(prefix)+" has no digits")
 	}
 
-exponent:
-	if S.ch == 'e' || S.ch == 'E' {
-		tok = token.FLOAT
+	// exponent
+	if e := lower(S.ch); e == 'e' || e == 'p' {
+		switch {
+		case e == 'e' && prefix != 0 && prefix != '0':
+			S.error(S.offset, fmt.Sprintf("%q exponent requires decimal mantissa", S.ch))
+		case e == 'p' && prefix != 'x':
+			S.error(S.offset, fmt.Sprintf("%q exponent requires hexadecimal mantissa", S.ch))
+		}
 		S.next()
-		if S.ch == '-' || S.ch == '+' {
+		tok = token.FLOAT
+		if S.ch == '+' || S.ch == '-' {
 			S.next()
 		}
-		S.scanMantissa(10)
+		ds := S.digits(10, nil)
+		digsep |= ds
+		if ds&1 == 0 {
+			S.error(S.offset, "exponent has no digits")
+		}
+	} else if prefix == 'x' && tok == token.FLOAT {
+		S.error(S.offset, "hexadecimal mantissa requires a 'p' exponent")
 	}
 
+	// suffix 'i'
 	if S.ch == 'i' {
 		tok = token.IMAG
 		S.next()
 	}
 
-exit:
+	lit := S.src[offs:S.offset]
+	if tok == token.INT && invalid >= 0 {
+		S.error(invalid, fmt.Sprintf("invalid digit %q in %s", lit[invalid-offs], litname(prefix)))
+	}
+	if digsep&2 != 0 {
+		if i := invalidSep(lit); i >= 0 {
+			S.error(offs+i, "'_' must separate successive digits")
+		}
+	}
 	return tok
 }
 
+func litname(prefix rune) string {
+	switch prefix {
+	case 'x':
+		return "hexadecimal literal"
+	case 'o', '0':
+		return "octal literal"
+	case 'b':
+		return "binary literal"
+	}
+	return "decimal literal"
+}
+
+// invalidSep returns the index of the first invalid separator in x, or -1.
+func invalidSep(x []byte) int {
+	x1 := ' ' // prefix char, we only care if it's 'x'
+	d := '.'  // digit, one of '_', '0' (a digit), or '.' (anything else)
+	i := 0
+
+	// a prefix counts as a digit
+	if len(x) >= 2 && x[0] == '0' {
+		x1 = lower(rune(x[1]))
+		if x1 == 'x' || x1 == 'o' || x1 == 'b' {
+			d = '0'
+			i = 2
+		}
+	}
+
+	// mantissa and exponent
+	for ; i < len(x); i++ {
+		p := d // previous digit
+		d = rune(x[i])
+		switch {
+		case d == '_':
+			if p != '0' {
+				return i
+			}
+		case isDecimal(d) || x1 == 'x' && isHex(d):
+			d = '0'
+		default:
+			if p == '_' {
+				return i - 1
+			}
+			d = '.'
+		}
+	}
+	if d == '_' {
+		return len(x) - 1
+	}
+	return -1
+}
+
 func (S *Scanner) scanEscape(quote rune) {
 	offs := S.offset
 
@@ -646,6 +749,8 @@ scanAgain:
 			}
 		case '|':
 			tok = S.switch3(token.OR, token.OR_ASSIGN, '|', token.LOR)
+		case '~':
+			tok = token.TILDE
 		default:
 			if S.mode&AllowIllegalChars == 0 {
 				S.error(offs, fmt.Sprintf("illegal character %#U", ch))
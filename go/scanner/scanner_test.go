@@ -55,6 +55,12 @@ var tokens = [...]elt{
 	{token.INT, "123456789012345678890", literal},
 	{token.INT, "01234567", literal},
 	{token.INT, "0xcafebabe", literal},
+	{token.INT, "0XCAFE_BABE", literal},
+	{token.INT, "0b1010", literal},
+	{token.INT, "0B_1010", literal},
+	{token.INT, "0o755", literal},
+	{token.INT, "0O7_5_5", literal},
+	{token.INT, "1_000_000", literal},
 	{token.FLOAT, "0.", literal},
 	{token.FLOAT, ".0", literal},
 	{token.FLOAT, "3.14159265", literal},
@@ -62,6 +68,10 @@ var tokens = [...]elt{
 	{token.FLOAT, "1e+100", literal},
 	{token.FLOAT, "1e-100", literal},
 	{token.FLOAT, "2.71828e-1000", literal},
+	{token.FLOAT, "1_000.000_1", literal},
+	{token.FLOAT, "0x1p-2", literal},
+	{token.FLOAT, "0X1.8P+1", literal},
+	{token.FLOAT, "0x_1.fp1_0", literal},
 	{token.IMAG, "0i", literal},
 	{token.IMAG, "1i", literal},
 	{token.IMAG, "012345678901234567889i", literal},
@@ -73,6 +83,8 @@ var tokens = [...]elt{
 	{token.IMAG, "1e+100i", literal},
 	{token.IMAG, "1e-100i", literal},
 	{token.IMAG, "2.71828e-1000i", literal},
+	{token.IMAG, "0b101i", literal},
+	{token.IMAG, "0x1p-2i", literal},
 	{token.CHAR, "'a'", literal},
 	{token.CHAR, "'\\000'", literal},
 	{token.CHAR, "'\\xFF'", literal},
@@ -141,6 +153,7 @@ var tokens = [...]elt{
 	{token.RBRACE, "}", operator},
 	{token.SEMICOLON, ";", operator},
 	{token.COLON, ":", operator},
+	{token.TILDE, "~", operator},
 
 	// Keywords
 	{token.BREAK, "break", keyword},
@@ -658,10 +671,21 @@ var errors = []struct {
 	{"078.", token.FLOAT, 0, ""},
 	{"07801234567.", token.FLOAT, 0, ""},
 	{"078e0", token.FLOAT, 0, ""},
-	{"078", token.INT, 0, "illegal octal number"},
-	{"07800000009", token.INT, 0, "illegal octal number"},
-	{"0x", token.INT, 0, "illegal hexadecimal number"},
-	{"0X", token.INT, 0, "illegal hexadecimal number"},
+	{"078", token.INT, 2, "invalid digit '8' in octal literal"},
+	{"07800000009", token.INT, 2, "invalid digit '8' in octal literal"},
+	{"0x", token.INT, 2, "hexadecimal literal has no digits"},
+	{"0X", token.INT, 2, "hexadecimal literal has no digits"},
+	{"0b", token.INT, 2, "binary literal has no digits"},
+	{"0b102", token.INT, 4, "invalid digit '2' in binary literal"},
+	{"0o8", token.INT, 2, "invalid digit '8' in octal literal"},
+	{"0o1.0", token.FLOAT, 3, "invalid radix point in octal literal"},
+	{"0x1.0", token.FLOAT, 5, "hexadecimal mantissa requires a 'p' exponent"},
+	{"0b1e2", token.FLOAT, 3, "'e' exponent requires decimal mantissa"},
+	{"1p2", token.FLOAT, 1, "'p' exponent requires hexadecimal mantissa"},
+	{"1e", token.FLOAT, 2, "exponent has no digits"},
+	{"1__0", token.INT, 2, "'_' must separate successive digits"},
+	{"1_", token.INT, 1, "'_' must separate successive digits"},
+	{"0x_1", token.INT, 0, ""},
 	{"\"abc\x00def\"", token.STRING, 4, "illegal character NUL"},
 	{"\"abc\x80def\"", token.STRING, 4, "illegal UTF-8 encoding"},
 }
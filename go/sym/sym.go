@@ -5,7 +5,6 @@ package sym
 import (
 	"bytes"
 	"fmt"
-	"go/build"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -73,7 +72,7 @@ func (ctxt *Context) importerFunc() types.Importer {
 		if srcDir == "" {
 			srcDir, _ = os.Getwd() // TODO put this into Context?
 		}
-		bpkg, err := build.Import(path, srcDir, 0)
+		bpkg, err := types.FindPackage(path, srcDir, 0)
 		if err != nil {
 			ctxt.logf(token.NoPos, "cannot find %q: %v", path, err)
 			return nil
@@ -87,6 +87,7 @@ const (
 	RBRACE    // }
 	SEMICOLON // ;
 	COLON     // :
+	TILDE     // ~
 	operator_end
 
 	keyword_beg
@@ -191,6 +192,7 @@ var tokens = [...]string{
 	RBRACE:    "}",
 	SEMICOLON: ";",
 	COLON:     ":",
+	TILDE:     "~",
 
 	BREAK:    "break",
 	CASE:     "case",
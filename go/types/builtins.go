@@ -0,0 +1,136 @@
+package types
+
+import (
+	"github.com/bobg/godef/go/ast"
+	"github.com/bobg/godef/go/parser"
+)
+
+// builtinCall returns the type of the call n when it calls a
+// predeclared function or a function of package unsafe, whose result
+// types depend on their arguments. The type is badType for those
+// with no result. It returns false if n calls any other function.
+// The predeclared make and new are left to the caller.
+func (ctxt *exprTypeContext) builtinCall(n *ast.CallExpr, pkg string) (Type, bool) {
+	var name string
+	switch fn := noParens(n.Fun).(type) {
+	case *ast.Ident:
+		if fn.Obj == nil || fn.Obj.Kind != ast.Fun || parser.Universe.Lookup(fn.Obj.Name) != fn.Obj {
+			return badType, false
+		}
+		name = fn.Name
+	case *ast.SelectorExpr:
+		x, _ := fn.X.(*ast.Ident)
+		if x == nil || x.Obj == nil || x.Obj.Kind != ast.Pkg {
+			return badType, false
+		}
+		if spec, ok := x.Obj.Decl.(*ast.ImportSpec); !ok || litToString(spec.Path) != "unsafe" {
+			return badType, false
+		}
+		name = "unsafe." + fn.Sel.Name
+	default:
+		return badType, false
+	}
+	arg := func(i int) Type {
+		if i >= len(n.Args) {
+			return badType
+		}
+		_, t := ctxt.exprType(n.Args[i], false, pkg)
+		return t
+	}
+	// kind returns Con if all the arguments are constant, and Var otherwise.
+	kind := func() ast.ObjKind {
+		for i := range n.Args {
+			if arg(i).Kind != ast.Con {
+				return ast.Var
+			}
+		}
+		return ast.Con
+	}
+
+	switch name {
+	case "clear", "close", "delete", "panic", "print", "println":
+		return badType, true
+
+	case "len", "cap", "copy":
+		return ctxt.newType(intIdent, ast.Var, ""), true
+
+	case "append":
+		t := arg(0)
+		if t.Kind != ast.Bad {
+			t.Kind = ast.Var
+		}
+		return t, true
+
+	case "min", "max":
+		// As for binary operators, the result has the type
+		// of the typed operands, if any.
+		t := arg(0)
+		for i := 1; i < len(n.Args) && t.Kind == ast.Con; i++ {
+			if u := arg(i); u.Kind == ast.Var {
+				t = u
+			}
+		}
+		return t, true
+
+	case "complex":
+		if basicType(arg(0)) == float32Ident.Obj || basicType(arg(1)) == float32Ident.Obj {
+			return ctxt.newType(complex64Ident, kind(), ""), true
+		}
+		return ctxt.newType(complex128Ident, kind(), ""), true
+
+	case "real", "imag":
+		if basicType(arg(0)) == complex64Ident.Obj {
+			return ctxt.newType(float32Ident, kind(), ""), true
+		}
+		return ctxt.newType(float64Ident, kind(), ""), true
+
+	case "recover":
+		return ctxt.certify(predecl("any"), ast.Var, ""), true
+
+	case "unsafe.Alignof", "unsafe.Offsetof", "unsafe.Sizeof":
+		return ctxt.newType(predecl("uintptr"), ast.Con, ""), true
+
+	case "unsafe.Add":
+		t := arg(0)
+		if t.Kind != ast.Bad {
+			t.Kind = ast.Var
+		}
+		return t, true
+
+	case "unsafe.Slice":
+		t := arg(0)
+		if ptr, ok := t.Underlying(true).Node.(*ast.StarExpr); ok {
+			return ctxt.newType(&ast.ArrayType{Lbrack: n.Pos(), Elt: ptr.X}, ast.Var, t.Pkg), true
+		}
+		return badType, true
+
+	case "unsafe.SliceData":
+		t := arg(0).Underlying(true)
+		if slice, ok := t.Node.(*ast.ArrayType); ok && slice.Len == nil {
+			return ctxt.newType(&ast.StarExpr{Star: n.Pos(), X: slice.Elt}, ast.Var, t.Pkg), true
+		}
+		return badType, true
+
+	case "unsafe.String":
+		return ctxt.newType(stringIdent, ast.Var, ""), true
+
+	case "unsafe.StringData":
+		return ctxt.newType(&ast.StarExpr{Star: n.Pos(), X: predecl("byte")}, ast.Var, ""), true
+	}
+	return badType, false
+}
+
+// basicType returns the object of the predeclared
+// type underlying t, or nil if there is none.
+func basicType(t Type) *ast.Object {
+	for {
+		id, _ := t.Node.(*ast.Ident)
+		if id == nil || id.Obj == nil || id.Obj.Kind != ast.Typ {
+			return nil
+		}
+		if parser.Universe.Lookup(id.Obj.Name) == id.Obj {
+			return id.Obj
+		}
+		t = t.Underlying(false)
+	}
+}
@@ -0,0 +1,90 @@
+package types
+
+import (
+	"go/constant"
+	gotoken "go/token"
+
+	"github.com/bobg/godef/go/ast"
+	"github.com/bobg/godef/go/token"
+)
+
+// goTokens maps the tokens of literals and constant operators
+// to their go/token counterparts, as go/constant uses them.
+var goTokens = map[token.Token]gotoken.Token{
+	token.INT:     gotoken.INT,
+	token.FLOAT:   gotoken.FLOAT,
+	token.IMAG:    gotoken.IMAG,
+	token.CHAR:    gotoken.CHAR,
+	token.STRING:  gotoken.STRING,
+	token.ADD:     gotoken.ADD,
+	token.SUB:     gotoken.SUB,
+	token.MUL:     gotoken.MUL,
+	token.QUO:     gotoken.QUO,
+	token.REM:     gotoken.REM,
+	token.AND:     gotoken.AND,
+	token.OR:      gotoken.OR,
+	token.XOR:     gotoken.XOR,
+	token.AND_NOT: gotoken.AND_NOT,
+	token.LAND:    gotoken.LAND,
+	token.LOR:     gotoken.LOR,
+	token.NOT:     gotoken.NOT,
+	token.SHL:     gotoken.SHL,
+	token.SHR:     gotoken.SHR,
+	token.EQL:     gotoken.EQL,
+	token.NEQ:     gotoken.NEQ,
+	token.LSS:     gotoken.LSS,
+	token.LEQ:     gotoken.LEQ,
+	token.GTR:     gotoken.GTR,
+	token.GEQ:     gotoken.GEQ,
+}
+
+// ConstValue returns the value of the constant expression x, which
+// may combine literals of any form, such as 0b1010, 1_000 or 0x1p-2,
+// with operators and parentheses. It returns nil if x uses anything
+// else, such as a named constant or iota, or if it cannot be evaluated.
+func ConstValue(x ast.Expr) (v constant.Value) {
+	// go/constant panics on operands of the wrong kinds.
+	defer func() {
+		if recover() != nil {
+			v = nil
+		}
+	}()
+	switch x := x.(type) {
+	case *ast.BasicLit:
+		if tok, ok := goTokens[x.Kind]; ok {
+			if v := constant.MakeFromLiteral(x.Value, tok, 0); v.Kind() != constant.Unknown {
+				return v
+			}
+		}
+	case *ast.ParenExpr:
+		return ConstValue(x.X)
+	case *ast.UnaryExpr:
+		tok, ok := goTokens[x.Op]
+		if y := ConstValue(x.X); ok && y != nil {
+			return constant.UnaryOp(tok, y, 0)
+		}
+	case *ast.BinaryExpr:
+		tok, ok := goTokens[x.Op]
+		a, b := ConstValue(x.X), ConstValue(x.Y)
+		if !ok || a == nil || b == nil {
+			return nil
+		}
+		switch x.Op {
+		case token.SHL, token.SHR:
+			if s, ok := constant.Uint64Val(constant.ToInt(b)); ok {
+				return constant.Shift(a, tok, uint(s))
+			}
+		case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+			return constant.MakeBool(constant.Compare(a, tok, b))
+		case token.QUO:
+			// Untyped integer constants divide as integers.
+			if a.Kind() == constant.Int && b.Kind() == constant.Int {
+				tok = gotoken.QUO_ASSIGN
+			}
+			return constant.BinaryOp(a, tok, b)
+		default:
+			return constant.BinaryOp(a, tok, b)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package types
+
+import (
+	"github.com/bobg/godef/go/ast"
+)
+
+// instantiation returns the object and type of the generic type
+// instantiated by n, as in List[int] or pkg.Map[string, int], and the
+// type arguments of the instantiation. It returns a nil object if n
+// is not the instantiation of a type.
+func (ctxt *exprTypeContext) instantiation(n ast.Node, pkg string) (*ast.Object, Type, []ast.Expr) {
+	var x ast.Expr
+	var args []ast.Expr
+	switch n := n.(type) {
+	case *ast.IndexExpr:
+		x, args = n.X, []ast.Expr{n.Index}
+	case *ast.IndexListExpr:
+		x, args = n.X, n.Indices
+	default:
+		return nil, badType, nil
+	}
+	obj, t := ctxt.exprType(x, false, pkg)
+	if obj == nil || obj.Kind != ast.Typ || t.Kind != ast.Typ {
+		return nil, badType, nil
+	}
+	return obj, t, args
+}
+
+// instantiate returns the type of the instantiation n of the generic
+// type or function whose object and type are obj and t.
+func (ctxt *exprTypeContext) instantiate(n ast.Expr, obj *ast.Object, t Type, args []ast.Expr) Type {
+	switch t.Kind {
+	case ast.Typ:
+		return ctxt.newType(n, ast.Typ, t.Pkg)
+	case ast.Fun:
+		if obj == nil {
+			break
+		}
+		if fd, ok := obj.Decl.(*ast.FuncDecl); ok && fd.Type.TypeParams != nil {
+			s := make(substitution)
+			s.bind(typeParamNames(fd.Type.TypeParams), args)
+			return ctxt.certify(s.apply(fd.Type), ast.Fun, t.Pkg)
+		}
+	}
+	return badType
+}
+
+// typeArgs returns the substitution of type arguments for type
+// parameters to apply to the declaration of obj, a member of the type
+// t, when t is an instantiated generic type or a pointer to one. The
+// type parameters are those of the generic type and, when obj is a
+// method, those declared by its receiver.
+func (ctxt *exprTypeContext) typeArgs(t Type, obj *ast.Object) substitution {
+	n := noParens(t.Node)
+	if star, ok := n.(*ast.StarExpr); ok {
+		n = noParens(star.X)
+	}
+	node, _ := n.(ast.Node)
+	gen, _, args := ctxt.instantiation(node, t.Pkg)
+	if gen == nil {
+		return nil
+	}
+	s := make(substitution)
+	if ts, ok := gen.Decl.(*ast.TypeSpec); ok {
+		s.bind(typeParamNames(ts.TypeParams), args)
+	}
+	if fd, ok := obj.Decl.(*ast.FuncDecl); ok && fd.Recv != nil && len(fd.Recv.List) > 0 {
+		_, params := ast.RecvTypeParams(fd.Recv.List[0])
+		s.bind(params, args)
+	}
+	return s
+}
+
+// typeParamNames returns the names of the type parameters in list,
+// in order.
+func typeParamNames(list *ast.FieldList) []*ast.Ident {
+	if list == nil {
+		return nil
+	}
+	var names []*ast.Ident
+	for _, f := range list.List {
+		names = append(names, f.Names...)
+	}
+	return names
+}
+
+// recvConstraint returns the constraint of the type parameter named
+// name declared by the method receiver recv, which is that of the
+// corresponding type parameter of the receiver's generic type.
+func recvConstraint(name string, recv *ast.Field) ast.Node {
+	x, params := ast.RecvTypeParams(recv)
+	id, _ := x.(*ast.Ident)
+	if id == nil || id.Obj == nil {
+		return nil
+	}
+	ts, _ := id.Obj.Decl.(*ast.TypeSpec)
+	if ts == nil {
+		return nil
+	}
+	tparams := typeParamNames(ts.TypeParams)
+	for i, p := range params {
+		if p != nil && p.Name == name && i < len(tparams) {
+			if f, ok := tparams[i].Obj.Decl.(*ast.Field); ok {
+				return f.Type
+			}
+		}
+	}
+	return nil
+}
+
+// isRecvTypeParam reports whether obj is a type parameter declared
+// by the method receiver field that is its declaration.
+func isRecvTypeParam(obj *ast.Object, f *ast.Field) bool {
+	if obj.Kind != ast.Typ {
+		return false
+	}
+	for _, n := range f.Names {
+		if n.Name == obj.Name {
+			return false
+		}
+	}
+	return true
+}
+
+// A substitution maps the objects of type parameters
+// to the type arguments that replace them.
+type substitution map[*ast.Object]ast.Expr
+
+// bind adds to s the substitution of each of args for
+// the type parameter in params at the same index.
+func (s substitution) bind(params []*ast.Ident, args []ast.Expr) {
+	for i, p := range params {
+		if p != nil && p.Obj != nil && i < len(args) {
+			s[p.Obj] = args[i]
+		}
+	}
+}
+
+// apply returns a copy of the type expression n
+// in which type parameters are replaced according to s.
+// Parts of n that refer to no type parameter may be shared.
+func (s substitution) apply(n ast.Node) ast.Node {
+	if len(s) == 0 {
+		return n
+	}
+	switch n := n.(type) {
+	case *ast.Ident:
+		if x, ok := s[n.Obj]; ok && n.Obj != nil {
+			return x
+		}
+	case *ast.ParenExpr:
+		return &ast.ParenExpr{Lparen: n.Lparen, X: s.expr(n.X), Rparen: n.Rparen}
+	case *ast.StarExpr:
+		return &ast.StarExpr{Star: n.Star, X: s.expr(n.X)}
+	case *ast.UnaryExpr:
+		return &ast.UnaryExpr{OpPos: n.OpPos, Op: n.Op, X: s.expr(n.X)}
+	case *ast.BinaryExpr:
+		return &ast.BinaryExpr{X: s.expr(n.X), OpPos: n.OpPos, Op: n.Op, Y: s.expr(n.Y)}
+	case *ast.IndexExpr:
+		return &ast.IndexExpr{X: s.expr(n.X), Lbrack: n.Lbrack, Index: s.expr(n.Index), Rbrack: n.Rbrack}
+	case *ast.IndexListExpr:
+		indices := make([]ast.Expr, len(n.Indices))
+		for i, x := range n.Indices {
+			indices[i] = s.expr(x)
+		}
+		return &ast.IndexListExpr{X: s.expr(n.X), Lbrack: n.Lbrack, Indices: indices, Rbrack: n.Rbrack}
+	case *ast.ArrayType:
+		return &ast.ArrayType{Lbrack: n.Lbrack, Len: n.Len, Elt: s.expr(n.Elt)}
+	case *ast.Ellipsis:
+		return &ast.Ellipsis{Ellipsis: n.Ellipsis, Elt: s.expr(n.Elt)}
+	case *ast.MapType:
+		return &ast.MapType{Map: n.Map, Key: s.expr(n.Key), Value: s.expr(n.Value)}
+	case *ast.ChanType:
+		return &ast.ChanType{Begin: n.Begin, Dir: n.Dir, Value: s.expr(n.Value)}
+	case *ast.FuncType:
+		// The result is no longer generic.
+		return &ast.FuncType{Func: n.Func, Params: s.fields(n.Params), Results: s.fields(n.Results)}
+	case *ast.StructType:
+		return &ast.StructType{Struct: n.Struct, Fields: s.fields(n.Fields), Incomplete: n.Incomplete}
+	case *ast.InterfaceType:
+		return &ast.InterfaceType{Interface: n.Interface, Methods: s.fields(n.Methods), Incomplete: n.Incomplete}
+	}
+	return n
+}
+
+func (s substitution) expr(x ast.Expr) ast.Expr {
+	if x == nil {
+		return nil
+	}
+	return s.apply(x).(ast.Expr)
+}
+
+// fields returns a copy of list with types substituted. The copied
+// fields keep their names, so that members found in them are those
+// of the original declaration.
+func (s substitution) fields(list *ast.FieldList) *ast.FieldList {
+	if list == nil {
+		return nil
+	}
+	fields := make([]*ast.Field, len(list.List))
+	for i, f := range list.List {
+		fields[i] = &ast.Field{Doc: f.Doc, Names: f.Names, Type: s.expr(f.Type), Tag: f.Tag, Comment: f.Comment}
+	}
+	return &ast.FieldList{Opening: list.Opening, List: fields, Closing: list.Closing}
+}
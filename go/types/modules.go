@@ -0,0 +1,195 @@
+package types
+
+import (
+	"go/build"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// moduleDir returns the directory of the package with the given import
+// path as imported from srcDir, when srcDir is in a module that provides
+// the package itself, vendors it, or requires the module providing it.
+// Replace directives are applied to required modules, whose directories
+// are otherwise found in the module cache. Standard library packages,
+// and those of modules that are not required directly, are left to the
+// go command. As for the go command, GO111MODULE, GOMODCACHE and GOFLAGS
+// are taken from env.
+func moduleDir(path, srcDir string, env []string) (string, bool) {
+	if Getenv(env, "GO111MODULE") == "off" || build.IsLocalImport(path) || isStdPackage(path) {
+		return "", false
+	}
+	m := findModule(srcDir)
+	if m == nil {
+		return "", false
+	}
+	if rest, ok := hasPathPrefix(path, m.path); ok {
+		return filepath.Join(m.dir, rest), true
+	}
+	if vendorEnabled(m, env) {
+		dir := filepath.Join(m.dir, "vendor", filepath.FromSlash(path))
+		return dir, isDir(dir)
+	}
+	best := ""
+	for modPath := range m.requires {
+		if _, ok := hasPathPrefix(path, modPath); ok && len(modPath) > len(best) {
+			best = modPath
+		}
+	}
+	if best == "" {
+		return "", false
+	}
+	rest, _ := hasPathPrefix(path, best)
+	modPath, version := best, m.requires[best]
+	for _, r := range m.replaces {
+		if r.Old.Path != modPath || (r.Old.Version != "" && r.Old.Version != version) {
+			continue
+		}
+		if modfile.IsDirectoryPath(r.New.Path) {
+			dir := filepath.FromSlash(r.New.Path)
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(m.dir, dir)
+			}
+			return filepath.Join(dir, rest), true
+		}
+		modPath, version = r.New.Path, r.New.Version
+	}
+	escPath, err := module.EscapePath(modPath)
+	if err != nil {
+		return "", false
+	}
+	escVersion, err := module.EscapeVersion(version)
+	if err != nil {
+		return "", false
+	}
+	dir := filepath.Join(modCache(env), filepath.FromSlash(escPath)+"@"+escVersion, rest)
+	return dir, isDir(dir)
+}
+
+// hasPathPrefix reports whether the import path is prefix or is
+// inside it, and if so returns the rest of path as a file path.
+func hasPathPrefix(path, prefix string) (string, bool) {
+	if path == prefix {
+		return "", true
+	}
+	if strings.HasPrefix(path, prefix+"/") {
+		return filepath.FromSlash(path[len(prefix)+1:]), true
+	}
+	return "", false
+}
+
+// isStdPackage reports whether path is the import path of a package in
+// the standard library.
+func isStdPackage(path string) bool {
+	return BuildContext.GOROOT != "" && isDir(filepath.Join(BuildContext.GOROOT, "src", filepath.FromSlash(path)))
+}
+
+func isDir(dir string) bool {
+	fi, err := os.Stat(dir)
+	return err == nil && fi.IsDir()
+}
+
+// modCache returns the directory of the module cache.
+func modCache(env []string) string {
+	if dir := Getenv(env, "GOMODCACHE"); dir != "" {
+		return dir
+	}
+	gopath := filepath.SplitList(BuildContext.GOPATH)
+	if len(gopath) == 0 {
+		return ""
+	}
+	return filepath.Join(gopath[0], "pkg", "mod")
+}
+
+// modInfo holds what moduleDir needs to know of a go.mod file.
+// Its vendor field reports whether the vendor directory is used by default.
+type modInfo struct {
+	modTime  time.Time
+	dir      string
+	path     string
+	vendor   bool
+	requires map[string]string
+	replaces []*modfile.Replace
+}
+
+var modules = struct {
+	mu     sync.Mutex
+	byFile map[string]*modInfo
+}{byFile: make(map[string]*modInfo)}
+
+// findModule returns the module containing dir, or nil if there is none.
+func findModule(dir string) *modInfo {
+	gomod := FindUp(dir, "go.mod")
+	if gomod == "" {
+		return nil
+	}
+	fi, err := os.Stat(gomod)
+	if err != nil {
+		return nil
+	}
+	modules.mu.Lock()
+	defer modules.mu.Unlock()
+	if m := modules.byFile[gomod]; m != nil && m.modTime.Equal(fi.ModTime()) {
+		return m
+	}
+	m := parseModule(gomod)
+	if m != nil {
+		m.modTime = fi.ModTime()
+		modules.byFile[gomod] = m
+	}
+	return m
+}
+
+// parseModule reads the go.mod file gomod.
+func parseModule(gomod string) *modInfo {
+	data, err := os.ReadFile(gomod)
+	if err != nil {
+		return nil
+	}
+	mf, err := modfile.Parse(gomod, data, nil)
+	if err != nil || mf.Module == nil {
+		debugp("cannot parse %s: %v", gomod, err)
+		return nil
+	}
+	m := &modInfo{
+		dir:      filepath.Dir(gomod),
+		path:     mf.Module.Mod.Path,
+		requires: make(map[string]string),
+		replaces: mf.Replace,
+	}
+	for _, r := range mf.Require {
+		m.requires[r.Mod.Path] = r.Mod.Version
+	}
+	m.vendor = vendorDefault(m.dir, mf)
+	return m
+}
+
+// vendorEnabled reports whether the go command uses the vendor directory
+// of the module m: by default, unless GOFLAGS in env says otherwise.
+func vendorEnabled(m *modInfo, env []string) bool {
+	for _, flag := range strings.Fields(Getenv(env, "GOFLAGS")) {
+		switch flag {
+		case "-mod=vendor":
+			return true
+		case "-mod=mod", "-mod=readonly":
+			return false
+		}
+	}
+	return m.vendor
+}
+
+// vendorDefault reports whether the go command uses the vendor directory
+// of the module in dir by default: when it exists and the module requires
+// Go 1.14 or later.
+func vendorDefault(dir string, mf *modfile.File) bool {
+	if _, err := os.Stat(filepath.Join(dir, "vendor", "modules.txt")); err != nil {
+		return false
+	}
+	return mf.Go != nil && semver.Compare("v"+mf.Go.Version, "v1.14") >= 0
+}
@@ -0,0 +1,80 @@
+package types
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestModuleDir(t *testing.T) {
+	root := t.TempDir()
+	cache := filepath.Join(root, "cache")
+	for name, data := range map[string]string{
+		"main/go.mod": `module example.com/main
+
+go 1.21
+
+require (
+	example.com/dep v1.0.0
+	example.com/Upper v1.2.3
+	example.com/renamed v1.0.0
+)
+
+replace example.com/dep => ../dep
+
+replace example.com/renamed v1.0.0 => example.com/other v1.1.0
+`,
+		"main/sub/sub.go": "package sub\n",
+		"dep/pkg/pkg.go":  "package pkg\n",
+		"cache/example.com/!upper@v1.2.3/up/up.go":   "package up\n",
+		"cache/example.com/other@v1.1.0/other.go":    "package other\n",
+		"vendored/go.mod":                            "module example.com/vendored\n\ngo 1.20\n\nrequire example.com/dep v1.0.0\n",
+		"vendored/vendor/modules.txt":                "# example.com/dep v1.0.0\n## explicit\nexample.com/dep/pkg\n",
+		"vendored/vendor/example.com/dep/pkg/pkg.go": "package pkg\n",
+	} {
+		name = filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("GOMODCACHE", cache)
+	t.Setenv("GOWORK", "off")
+	t.Setenv("GOFLAGS", "")
+	t.Setenv("GO111MODULE", "")
+
+	for _, test := range []struct {
+		path, srcDir, want string
+	}{
+		{"example.com/main/sub", "main", "main/sub"},
+		{"example.com/dep/pkg", "main/sub", "dep/pkg"},
+		{"example.com/Upper/up", "main", "cache/example.com/!upper@v1.2.3/up"},
+		{"example.com/renamed", "main", "cache/example.com/other@v1.1.0"},
+		{"example.com/dep/pkg", "vendored", "vendored/vendor/example.com/dep/pkg"},
+		{"example.com/unknown", "main", ""},
+		{"fmt", "main", ""},
+	} {
+		dir, ok := moduleDir(test.path, filepath.Join(root, test.srcDir), nil)
+		if test.want == "" {
+			if ok {
+				t.Errorf("%s from %s: got %s, want not found", test.path, test.srcDir, dir)
+			}
+			continue
+		}
+		if want := filepath.Join(root, filepath.FromSlash(test.want)); !ok || dir != want {
+			t.Errorf("%s from %s: got %s, %v want %s", test.path, test.srcDir, dir, ok, want)
+		}
+	}
+
+	env := []string{"GOMODCACHE=" + cache, "GOFLAGS=-mod=mod"}
+	if dir, ok := moduleDir("example.com/dep/pkg", filepath.Join(root, "vendored"), env); ok {
+		t.Errorf("with GOFLAGS=-mod=mod got %s", dir)
+	}
+
+	name, err := DefaultImportPathToName("example.com/Upper/up", filepath.Join(root, "main"))
+	if err != nil || name != "up" {
+		t.Errorf("got package name %q, %v want up", name, err)
+	}
+}
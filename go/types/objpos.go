@@ -21,6 +21,13 @@ func declPos(name string, decl ast.Node) token.Pos {
 				return n.Pos()
 			}
 		}
+		// A method receiver declares its type parameters.
+		_, params := ast.RecvTypeParams(d)
+		for _, n := range params {
+			if n != nil && n.Name == name {
+				return n.Pos()
+			}
+		}
 	case *ast.ValueSpec:
 		for _, n := range d.Names {
 			if n.Name == name {
@@ -13,6 +13,8 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/bobg/godef/go/ast"
 	"github.com/bobg/godef/go/parser"
@@ -62,8 +64,12 @@ var trueIdent = predecl("true")
 var iotaIdent = predecl("iota")
 var boolIdent = predecl("bool")
 var intIdent = predecl("int")
-var floatIdent = predecl("float")
+var float32Ident = predecl("float32")
+var float64Ident = predecl("float64")
+var complex64Ident = predecl("complex64")
+var complex128Ident = predecl("complex128")
 var stringIdent = predecl("string")
+var runeIdent = predecl("rune")
 
 func predecl(name string) *ast.Ident {
 	return &ast.Ident{Name: name, Obj: parser.Universe.Lookup(name)}
@@ -71,13 +77,24 @@ func predecl(name string) *ast.Ident {
 
 type Importer func(path string, srcDir string) *ast.Package
 
+// BuildContext is used by FindPackage, and so by DefaultImporter and
+// DefaultImportPathToName, to find packages and select their files.
+var BuildContext = &build.Default
+
+// Env is the environment, as for the go command, in which FindPackage
+// looks for workspaces and modules. If it is nil, the process's
+// environment is used.
+var Env []string
+
 // When DefaultImporter is called, it adds any files to FileSet.
 var FileSet = token.NewFileSet()
 
 // DefaultImporter looks for the package; if it finds it,
 // it parses and returns it. If no package was found, it returns nil.
+// While CacheImports is on, parsed packages are reused until
+// their files change.
 func DefaultImporter(path string, srcDir string) *ast.Package {
-	bpkg, err := build.Default.Import(path, srcDir, 0)
+	bpkg, err := FindPackage(path, srcDir, 0)
 	if err != nil {
 		return nil
 	}
@@ -88,9 +105,13 @@ func DefaultImporter(path string, srcDir string) *ast.Package {
 	for _, f := range bpkg.CgoFiles {
 		goFiles[f] = true
 	}
+	if pkg := importCache.lookup(bpkg.Dir, goFiles); pkg != nil {
+		return pkg
+	}
 	shouldInclude := func(d os.FileInfo) bool {
 		return goFiles[d.Name()]
 	}
+	// Packages with syntax errors are used as far as they could be parsed.
 	pkgs, err := parser.ParseDir(FileSet, bpkg.Dir, shouldInclude, 0, DefaultImportPathToName)
 	if err != nil {
 		if Debug {
@@ -103,9 +124,9 @@ func DefaultImporter(path string, srcDir string) *ast.Package {
 				debugp("\terror parsing %s: %v", bpkg.Dir, err)
 			}
 		}
-		return nil
 	}
 	if pkg := pkgs[bpkg.Name]; pkg != nil {
+		importCache.store(bpkg.Dir, goFiles, pkg)
 		return pkg
 	}
 	if Debug {
@@ -114,13 +135,93 @@ func DefaultImporter(path string, srcDir string) *ast.Package {
 	return nil
 }
 
+// importCache holds the packages parsed by DefaultImporter
+// while CacheImports is on.
+var importCache = &packageCache{}
+
+// CacheImports turns on or off the reuse of the packages parsed by
+// DefaultImporter, which is only worthwhile in a long-running process
+// answering one query after another. Either way, the packages parsed
+// so far are forgotten.
+func CacheImports(on bool) {
+	importCache.mu.Lock()
+	defer importCache.mu.Unlock()
+	importCache.pkgs = nil
+	if on {
+		importCache.pkgs = make(map[string]*cachedPackage)
+	}
+}
+
+// packageCache maps a package directory to the package parsed from it.
+// It is disabled while pkgs is nil.
+type packageCache struct {
+	mu   sync.Mutex
+	pkgs map[string]*cachedPackage
+}
+
+// cachedPackage holds a parsed package and the modification
+// times of the files it was parsed from.
+type cachedPackage struct {
+	pkg      *ast.Package
+	modTimes map[string]time.Time
+}
+
+// lookup returns the package parsed from exactly the given files in
+// dir, or nil if there is none. If the files have changed, the whole
+// cache is cleared, as the packages parsed since may refer to the
+// declarations of the old one.
+func (c *packageCache) lookup(dir string, files map[string]bool) *ast.Package {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cp := c.pkgs[dir]
+	if cp == nil {
+		return nil
+	}
+	changed := len(cp.modTimes) != len(files)
+	for f := range files {
+		t, ok := cp.modTimes[f]
+		if !ok {
+			changed = true
+			break
+		}
+		fi, err := os.Stat(filepath.Join(dir, f))
+		if err != nil || !fi.ModTime().Equal(t) {
+			changed = true
+			break
+		}
+	}
+	if changed {
+		c.pkgs = make(map[string]*cachedPackage)
+		return nil
+	}
+	return cp.pkg
+}
+
+// store records pkg as parsed from the given files in dir.
+func (c *packageCache) store(dir string, files map[string]bool, pkg *ast.Package) {
+	cp := &cachedPackage{
+		pkg:      pkg,
+		modTimes: make(map[string]time.Time),
+	}
+	for f := range files {
+		if fi, err := os.Stat(filepath.Join(dir, f)); err == nil {
+			cp.modTimes[f] = fi.ModTime()
+		}
+	}
+	c.mu.Lock()
+	if c.pkgs != nil {
+		c.pkgs[dir] = cp
+	}
+	c.mu.Unlock()
+}
+
 // DefaultImportPathToName returns the package identifier
 // for the given import path.
 func DefaultImportPathToName(path, srcDir string) (string, error) {
 	if path == "C" {
 		return "C", nil
 	}
-	pkg, err := build.Default.Import(path, srcDir, 0)
+	pkg, err := FindPackage(path, srcDir, 0)
 	return pkg.Name, err
 }
 
@@ -297,22 +398,31 @@ func (ctxt *exprTypeContext) exprType(n ast.Node, expectTuple bool, pkg string)
 		// a method turns into a function type;
 		// the number of formal arguments depends
 		// on the class of the receiver expression.
+		// Members of an instantiated generic type
+		// have the type arguments substituted.
+		args := ctxt.typeArgs(t, obj)
 		if fd, ismethod := obj.Decl.(*ast.FuncDecl); ismethod {
 			if t.Kind == ast.Typ {
-				return obj, ctxt.certify(methodExpr(fd), ast.Fun, t.Pkg)
+				return obj, ctxt.certify(args.apply(methodExpr(fd)), ast.Fun, t.Pkg)
 			}
-			return obj, ctxt.certify(fd.Type, ast.Fun, t.Pkg)
+			return obj, ctxt.certify(args.apply(fd.Type), ast.Fun, t.Pkg)
 		} else if obj.Kind == ast.Typ {
 			return obj, ctxt.certify(&ast.Ident{Name: obj.Name, Obj: obj}, ast.Typ, t.Pkg)
 		}
 		_, typ := splitDecl(obj, nil)
-		return obj, ctxt.certify(typ, obj.Kind, t.Pkg)
+		return obj, ctxt.certify(args.apply(typ), obj.Kind, t.Pkg)
 
 	case *ast.FuncDecl:
 		return nil, ctxt.certify(methodExpr(n), ast.Fun, pkg)
 
 	case *ast.IndexExpr:
-		_, t0 := ctxt.exprType(n.X, false, pkg)
+		obj, t0 := ctxt.exprType(n.X, false, pkg)
+		// Indexing a generic type or function instantiates it.
+		if t0.Kind == ast.Typ || t0.Kind == ast.Fun {
+			if t := ctxt.instantiate(n, obj, t0, []ast.Expr{n.Index}); t.Kind != ast.Bad {
+				return obj, t
+			}
+		}
 		t := t0.Underlying(true)
 		switch n := t.Node.(type) {
 		case *ast.ArrayType:
@@ -325,6 +435,12 @@ func (ctxt *exprTypeContext) exprType(n ast.Node, expectTuple bool, pkg string)
 			return nil, t
 		}
 
+	case *ast.IndexListExpr:
+		obj, t := ctxt.exprType(n.X, false, pkg)
+		if t.Kind != ast.Bad {
+			return obj, ctxt.instantiate(n, obj, t, n.Indices)
+		}
+
 	case *ast.SliceExpr:
 		_, typ := ctxt.exprType(n.X, false, pkg)
 		return nil, typ
@@ -343,6 +459,9 @@ func (ctxt *exprTypeContext) exprType(n ast.Node, expectTuple bool, pkg string)
 				}
 			}
 		default:
+			if t, ok := ctxt.builtinCall(n, pkg); ok {
+				return nil, t
+			}
 			if _, fntype := ctxt.exprType(n.Fun, false, pkg); fntype.Kind != ast.Bad {
 				// A type cast transforms a type expression
 				// into a value expression.
@@ -460,11 +579,17 @@ func (ctxt *exprTypeContext) exprType(n ast.Node, expectTuple bool, pkg string)
 		case token.STRING:
 			id = stringIdent
 
-		case token.INT, token.CHAR:
+		case token.INT:
 			id = intIdent
 
+		case token.CHAR:
+			id = runeIdent
+
 		case token.FLOAT:
-			id = floatIdent
+			id = float64Ident
+
+		case token.IMAG:
+			id = complex128Ident
 
 		default:
 			debugp("unknown constant type %v", n.Kind)
@@ -494,6 +619,9 @@ func (ctxt *exprTypeContext) exprType(n ast.Node, expectTuple bool, pkg string)
 			return nil, ctxt.newType(&ast.ArrayType{n.Pos(), nil, t.Node.(ast.Expr)}, ast.Var, t.Pkg)
 		}
 
+	case *ast.BadExpr:
+		// Parts of the source that could not be parsed have no type.
+
 	default:
 		panic(fmt.Sprintf("unknown type %T", n))
 	}
@@ -556,8 +684,17 @@ func doTypeMembers(t Type, name string, fn func(*ast.Object), q *list.List) {
 	if u, ok := t.Node.(*ast.StarExpr); ok {
 		_, t = t.ctxt.exprType(u.X, false, t.Pkg)
 	}
-	if id, _ := t.Node.(*ast.Ident); id != nil && id.Obj != nil {
-		if scope, ok := id.Obj.Type.(*ast.Scope); ok {
+	var obj *ast.Object
+	switch n := t.Node.(type) {
+	case *ast.Ident:
+		obj = n.Obj
+	case *ast.IndexExpr, *ast.IndexListExpr:
+		// The methods of an instantiated type
+		// are those of the generic type.
+		obj, _, _ = t.ctxt.instantiation(n, t.Pkg)
+	}
+	if obj != nil {
+		if scope, ok := obj.Type.(*ast.Scope); ok {
 			doScope(scope, name, fn, t.Pkg)
 		}
 	}
@@ -634,6 +771,12 @@ func unnamedFieldName(t ast.Node) *ast.Ident {
 
 	case *ast.StarExpr:
 		return unnamedFieldName(t.X)
+
+	case *ast.IndexExpr:
+		return unnamedFieldName(t.X)
+
+	case *ast.IndexListExpr:
+		return unnamedFieldName(t.X)
 	}
 
 	panic("no name found for unnamed field")
@@ -662,15 +805,35 @@ func doScope(s *ast.Scope, name string, fn func(*ast.Object), pkg string) {
 // If typ represents a named type, Underlying returns
 // the type that it was defined as. If all is true,
 // it repeats this process until the type is not
-// a named type.
+// a named type. The underlying type of an instantiated
+// generic type has the type arguments substituted, and
+// that of a type parameter is its constraint.
 func (typ Type) Underlying(all bool) Type {
 	for {
-		id, _ := typ.Node.(*ast.Ident)
-		if id == nil || id.Obj == nil {
-			break
+		var typNode ast.Node
+		pkg := typ.Pkg
+		switch n := typ.Node.(type) {
+		case *ast.Ident:
+			if n.Obj == nil {
+				return typ
+			}
+			_, typNode = splitDecl(n.Obj, n)
+		case *ast.IndexExpr, *ast.IndexListExpr:
+			obj, gen, args := typ.ctxt.instantiation(n, typ.Pkg)
+			if obj == nil {
+				return typ
+			}
+			ts, _ := obj.Decl.(*ast.TypeSpec)
+			if ts == nil {
+				return typ
+			}
+			s := make(substitution)
+			s.bind(typeParamNames(ts.TypeParams), args)
+			typNode, pkg = s.apply(ts.Type), gen.Pkg
+		default:
+			return typ
 		}
-		_, typNode := splitDecl(id.Obj, id)
-		_, t := typ.ctxt.exprType(typNode, false, typ.Pkg)
+		_, t := typ.ctxt.exprType(typNode, false, pkg)
 		if t.Kind != ast.Typ {
 			return badType
 		}
@@ -683,6 +846,54 @@ func (typ Type) Underlying(all bool) Type {
 	return typ
 }
 
+// TypeName returns the object declaring the named type of typ, and the
+// type it declares. Pointers, slices, arrays, maps and channels are
+// followed to their elements, and functions to their first result.
+// If there is no such named type, TypeName returns nil.
+func (typ Type) TypeName() (*ast.Object, Type) {
+	n := typ.Node
+	for {
+		switch t := noParens(n).(type) {
+		case *ast.StarExpr:
+			n = t.X
+		case *ast.ArrayType:
+			n = t.Elt
+		case *ast.Ellipsis:
+			n = t.Elt
+		case *ast.MapType:
+			n = t.Value
+		case *ast.ChanType:
+			n = t.Value
+		case *ast.IndexExpr:
+			n = t.X
+		case *ast.IndexListExpr:
+			n = t.X
+		case *ast.FuncType:
+			if t.Results == nil || len(t.Results.List) == 0 {
+				return nil, badType
+			}
+			n = t.Results.List[0].Type
+		case *ast.Ident, *ast.SelectorExpr:
+			if typ.ctxt == nil {
+				return nil, badType
+			}
+			obj, ntyp := typ.ctxt.exprType(t.(ast.Expr), false, typ.Pkg)
+			if obj == nil || obj.Kind != ast.Typ || ntyp.Kind != ast.Typ {
+				return nil, badType
+			}
+			return obj, ntyp
+		case *ast.InterfaceType:
+			// The type of any has no name of its own.
+			if obj := parser.UniverseAliasOf(t); obj != nil {
+				return obj, typ.ctxt.newType(&ast.Ident{Name: obj.Name, Obj: obj}, ast.Typ, "")
+			}
+			return nil, badType
+		default:
+			return nil, badType
+		}
+	}
+}
+
 func noParens(typ interface{}) interface{} {
 	for {
 		if n, ok := typ.(*ast.ParenExpr); ok {
@@ -751,6 +962,9 @@ func splitDecl(obj *ast.Object, id *ast.Ident) (expr, typ ast.Node) {
 		return decl.Body, decl.Type
 
 	case *ast.Field:
+		if isRecvTypeParam(obj, decl) {
+			return nil, recvConstraint(obj.Name, decl)
+		}
 		return nil, decl.Type
 
 	case *ast.LabeledStmt:
@@ -870,7 +1084,8 @@ func isTypeAlias(obj *ast.Object) bool {
 		return false
 	}
 	ts, ok := obj.Decl.(*ast.TypeSpec)
-	return ok && ts.Assign.IsValid()
+	// Predeclared aliases, such as any, have no position.
+	return ok && (ts.Assign.IsValid() || parser.IsUniverseAlias(obj))
 }
 
 func fields2type(fields *ast.FieldList) ast.Node {
@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 	"unicode"
 
 	"github.com/bobg/godef/go/ast"
@@ -176,6 +177,90 @@ func TestCompile(t *testing.T) {
 }
 
 func TestOneFile(t *testing.T) {
+	testCodeSymbols(t, testCode)
+}
+
+func TestGenerics(t *testing.T) {
+	testCodeSymbols(t, genericsCode)
+}
+
+func TestBuiltinCalls(t *testing.T) {
+	testCodeSymbols(t, builtinCallsCode)
+}
+
+func TestImportCache(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "p.go")
+	if err := os.WriteFile(name, []byte("package p\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]bool{"p.go": true}
+	pkg := &ast.Package{Name: "p"}
+	defer CacheImports(false)
+
+	importCache.store(dir, files, pkg)
+	if got := importCache.lookup(dir, files); got != nil {
+		t.Errorf("package cached while CacheImports is off")
+	}
+	CacheImports(true)
+	importCache.store(dir, files, pkg)
+	importCache.store(filepath.Join(dir, "other"), files, pkg)
+	if got := importCache.lookup(dir, files); got != pkg {
+		t.Errorf("got %v want the cached package", got)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(name, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if got := importCache.lookup(dir, files); got != nil {
+		t.Errorf("got %v after the file changed", got)
+	}
+	if n := len(importCache.pkgs); n != 0 {
+		t.Errorf("%d packages left in the cache after a file changed", n)
+	}
+}
+
+func TestConstValue(t *testing.T) {
+	for _, test := range []struct {
+		expr, want string
+	}{
+		{"0b1010", "10"},
+		{"0o755", "493"},
+		{"0O7", "7"},
+		{"0755", "493"},
+		{"1_000_000", "1000000"},
+		{"0x_cafe", "51966"},
+		{"0x1p-2", "0.25"},
+		{"1_0.2_5", "10.25"},
+		{"0b1i", "(0 + 1i)"},
+		{"'a'", "97"},
+		{`"a" + "b"`, `"ab"`},
+		{"-(0b11 << 2)", "-12"},
+		{"7 / 2", "3"},
+		{"7 / 2.0", "3.5"},
+		{"1 < 0x2", "true"},
+		{"iota", ""},
+		{"1 / 0", ""},
+	} {
+		x, err := parser.ParseExpr(FileSet, "", test.expr, nil, DefaultImportPathToName)
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+		got := ""
+		if v := ConstValue(x); v != nil {
+			got = v.String()
+		}
+		if got != test.want {
+			t.Errorf("%s: got %q want %q", test.expr, got, test.want)
+		}
+	}
+}
+
+// testCodeSymbols checks that each symbol in the given test
+// code, as translated by translateSymbols, resolves to the
+// expected declaration.
+func testCodeSymbols(t *testing.T, testCode []byte) {
 	code, offsetMap := translateSymbols(testCode)
 	//fmt.Printf("------------------- {%s}\n", code)
 	f, err := parser.ParseFile(FileSet, "xx.go", code, 0, ast.NewScope(parser.Universe), DefaultImportPathToName)
@@ -306,7 +391,7 @@ func translateSymbols(code []byte) (result []byte, offsetMap map[int]*sym) {
 		}
 		name := sbuf.String()
 		if !strings.HasPrefix(name, prefix) {
-			sbuf.WriteString(name)
+			wbuf.WriteString(name)
 			continue
 		}
 		bareName := name
@@ -518,3 +603,79 @@ func xx_varargs@f(xx_args@v ... xx_struct) {
 
 func use(...interface{}) {}
 `)
+
+var genericsCode = []byte(
+	`package main
+
+type xx_list@t[xx_T@t any] struct {
+	xx_next@v *xx_list[xx_T]
+	xx_val@v  xx_T
+}
+
+func (xx_l@v *xx_list[xx_T#r@t]) xx_front@f() *xx_list[xx_T#r] {
+	return xx_l.xx_next
+}
+
+type xx_stringer@t interface {
+	xx_string#i@f() string
+}
+
+type xx_elem@t struct {
+	xx_name@v string
+}
+
+func (xx_elem) xx_string#e@f() string { return "" }
+
+type xx_set@t[xx_K@t xx_stringer] map[xx_K]bool
+
+func (xx_m@v xx_set[xx_K#r@t]) xx_keys@f() {
+	for xx_k@v := range xx_m {
+		xx_k.xx_string#i()
+	}
+}
+
+func xx_show@f[xx_S@t xx_stringer](xx_s@v xx_S) string {
+	return xx_s.xx_string#i()
+}
+
+func xx_pair@f[xx_A@t, xx_B@t any](xx_a@v xx_A, xx_b@v xx_B) xx_B {
+	return xx_b
+}
+
+func main() {
+	var xx_elems@v xx_list[xx_elem]
+	_ = xx_elems.xx_val.xx_name
+	_ = xx_elems.xx_front().xx_val.xx_name
+	_ = xx_elems.xx_next.xx_front().xx_val.xx_string#e()
+	_ = xx_list[xx_elem]{}.xx_val.xx_name
+
+	_ = xx_pair[int, xx_elem](1, xx_elem{}).xx_name
+	_ = xx_show[xx_elem]
+}
+`)
+
+var builtinCallsCode = []byte(
+	`package main
+
+import "unsafe"
+
+type xx_int@t int
+
+func (xx_int) xx_k@f() {}
+
+type xx_struct@t struct{}
+
+func (*xx_struct) xx_ptr@f() {}
+
+func main() {
+	var xxv_int@v xx_int
+	var xx_slice@v []xx_int
+	var xx_s@v xx_struct
+
+	min(xxv_int, 1).xx_k()
+	max(2, 1, xxv_int).xx_k()
+	append(xx_slice, 1)[0].xx_k()
+	unsafe.Slice(&xx_s, 1)[0].xx_ptr()
+	unsafe.SliceData(xx_slice).xx_k()
+}
+`)
@@ -0,0 +1,176 @@
+package types
+
+import (
+	"go/build"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/mod/modfile"
+)
+
+// FindPackage is like BuildContext.Import, except that packages provided
+// by the modules of the go.work workspace containing srcDir, if there is
+// one, are found in the workspace rather than in the module cache, and
+// that packages provided by the module containing srcDir, its vendor
+// directory or the modules it requires are found without running the
+// go command.
+func FindPackage(path, srcDir string, mode build.ImportMode) (*build.Package, error) {
+	dir, ok := workspaceDir(path, srcDir, Env)
+	if !ok {
+		dir, ok = moduleDir(path, srcDir, Env)
+	}
+	if ok {
+		pkg, err := BuildContext.ImportDir(dir, mode)
+		if pkg != nil {
+			pkg.ImportPath = path
+		}
+		return pkg, err
+	}
+	return BuildContext.Import(path, srcDir, mode)
+}
+
+// workspaceDir returns the directory of the package with the given
+// import path when it is provided by a module of the workspace containing
+// srcDir, either as one of the modules it uses or by a replace directive
+// naming a local directory.
+func workspaceDir(path, srcDir string, env []string) (string, bool) {
+	ws := findWorkspace(srcDir, env)
+	if ws == nil {
+		return "", false
+	}
+	best := ""
+	for modPath := range ws.modules {
+		if (path == modPath || strings.HasPrefix(path, modPath+"/")) && len(modPath) > len(best) {
+			best = modPath
+		}
+	}
+	if best == "" {
+		return "", false
+	}
+	return filepath.Join(ws.modules[best], filepath.FromSlash(strings.TrimPrefix(path, best))), true
+}
+
+// workspace holds the modules of a go.work file.
+type workspace struct {
+	modTime time.Time
+	// modules maps module paths to their directories.
+	modules map[string]string
+}
+
+var workspaces = struct {
+	mu     sync.Mutex
+	byFile map[string]*workspace
+}{byFile: make(map[string]*workspace)}
+
+// findWorkspace returns the workspace used by the go command in dir
+// with the environment env, or nil if there is none. As for the go
+// command, the GOWORK environment variable names the go.work file, or
+// disables workspaces if it is "off"; otherwise the nearest go.work file
+// in dir or one of its parents is used. Workspaces are not used outside
+// module mode.
+func findWorkspace(dir string, env []string) *workspace {
+	if Getenv(env, "GO111MODULE") == "off" {
+		return nil
+	}
+	gowork := Getenv(env, "GOWORK")
+	switch gowork {
+	case "off":
+		return nil
+	case "":
+		gowork = FindUp(dir, "go.work")
+		if gowork == "" {
+			return nil
+		}
+	}
+	fi, err := os.Stat(gowork)
+	if err != nil {
+		return nil
+	}
+	workspaces.mu.Lock()
+	defer workspaces.mu.Unlock()
+	if ws := workspaces.byFile[gowork]; ws != nil && ws.modTime.Equal(fi.ModTime()) {
+		return ws
+	}
+	ws := parseWorkspace(gowork)
+	if ws != nil {
+		ws.modTime = fi.ModTime()
+		workspaces.byFile[gowork] = ws
+	}
+	return ws
+}
+
+// parseWorkspace reads the go.work file gowork.
+func parseWorkspace(gowork string) *workspace {
+	data, err := os.ReadFile(gowork)
+	if err != nil {
+		return nil
+	}
+	wf, err := modfile.ParseWork(gowork, data, nil)
+	if err != nil {
+		debugp("cannot parse %s: %v", gowork, err)
+		return nil
+	}
+	root := filepath.Dir(gowork)
+	ws := &workspace{modules: make(map[string]string)}
+	for _, use := range wf.Use {
+		dir := filepath.FromSlash(use.Path)
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(root, dir)
+		}
+		data, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+		if err != nil {
+			continue
+		}
+		if modPath := modfile.ModulePath(data); modPath != "" {
+			ws.modules[modPath] = dir
+		}
+	}
+	for _, r := range wf.Replace {
+		if !modfile.IsDirectoryPath(r.New.Path) {
+			continue
+		}
+		dir := filepath.FromSlash(r.New.Path)
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(root, dir)
+		}
+		ws.modules[r.Old.Path] = dir
+	}
+	return ws
+}
+
+// Getenv returns the value of the named variable in env,
+// or in the process's environment if env is nil.
+func Getenv(env []string, name string) string {
+	if env == nil {
+		return os.Getenv(name)
+	}
+	value := ""
+	for _, e := range env {
+		if strings.HasPrefix(e, name+"=") {
+			value = e[len(name)+1:]
+		}
+	}
+	return value
+}
+
+// FindUp returns the file with the given name in dir or
+// the nearest of its parents, or "" if there is none.
+func FindUp(dir, name string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		if fi, err := os.Stat(filepath.Join(dir, name)); err == nil && !fi.IsDir() {
+			return filepath.Join(dir, name)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
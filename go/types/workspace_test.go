@@ -0,0 +1,62 @@
+package types
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWorkspace(t *testing.T) {
+	root := t.TempDir()
+	for name, data := range map[string]string{
+		"go.work":  "go 1.21\n\nuse (\n\t./a\n\t./b\n)\n\nreplace example.com/c => ./c\n",
+		"a/go.mod": "module example.com/a\n",
+		"a/a.go":   "package a\n",
+		"b/go.mod": "module example.com/a/b\n",
+		"b/x/x.go": "package x\n",
+		"c/go.mod": "module example.com/c\n",
+	} {
+		name = filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("GOWORK", "")
+	t.Setenv("GO111MODULE", "")
+
+	srcDir := filepath.Join(root, "a")
+	for path, want := range map[string]string{
+		"example.com/a":     "a",
+		"example.com/a/y":   "a/y",
+		"example.com/a/b/x": "b/x",
+		"example.com/c/z":   "c/z",
+		"example.com/ab":    "",
+		"fmt":               "",
+	} {
+		dir, ok := workspaceDir(path, srcDir, nil)
+		if want == "" {
+			if ok {
+				t.Errorf("%s: got %s, want not in workspace", path, dir)
+			}
+			continue
+		}
+		if want = filepath.Join(root, filepath.FromSlash(want)); !ok || dir != want {
+			t.Errorf("%s: got %s, %v want %s", path, dir, ok, want)
+		}
+	}
+
+	pkg, err := FindPackage("example.com/a/b/x", srcDir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkg.Name != "x" || pkg.Dir != filepath.Join(root, "b", "x") || pkg.ImportPath != "example.com/a/b/x" {
+		t.Errorf("got package %s in %s with path %s", pkg.Name, pkg.Dir, pkg.ImportPath)
+	}
+
+	if dir, ok := workspaceDir("example.com/a", srcDir, []string{"GOWORK=off"}); ok {
+		t.Errorf("with GOWORK=off got %s", dir)
+	}
+}
@@ -17,6 +17,7 @@ import (
 	debugpkg "runtime/debug"
 	"runtime/pprof"
 	"runtime/trace"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -29,14 +30,34 @@ import (
 )
 
 var readStdin = flag.Bool("i", false, "read file from stdin")
+var modifiedFlag = flag.Bool("modified", false, "read an archive of modified files from stdin")
 var offset = flag.Int("o", -1, "file offset of identifier in stdin")
+var posFlag = flag.String("pos", "", "position of identifier as file:line:column, instead of -f and -o")
+var unitsFlag = flag.String("units", byteUnits, "units in which the -pos column is counted: bytes, runes or utf16")
 var debug = flag.Bool("debug", false, "debug mode")
 var tflag = flag.Bool("t", false, "print type information")
 var aflag = flag.Bool("a", false, "print public type and member information")
 var Aflag = flag.Bool("A", false, "print all type and members information")
 var fflag = flag.String("f", "", "Go source filename")
 var acmeFlag = flag.Bool("acme", false, "use current acme window")
-var jsonFlag = flag.Bool("json", false, "output location in JSON format (-t flag is ignored)")
+var docFlag = flag.Bool("doc", false, "print the doc comment of the declaration")
+var typeDefFlag = flag.Bool("T", false, "find the declaration of the type of the identifier instead of the identifier")
+var jsonFlag = flag.Bool("json", false, "output in JSON format")
+var refsFlag = flag.Bool("refs", false, "print the locations of all references to the identifier")
+var callersFlag = flag.Bool("callers", false, "print the call sites of the function or method")
+var calleesFlag = flag.Bool("callees", false, "print the functions and methods called by the function or method")
+var implFlag = flag.Bool("impl", false, "print the locations of implementations of the interface or method, or of the interfaces implemented by the type or method")
+var completeFlag = flag.Bool("complete", false, "print the candidates for completing the identifier or selector before the offset")
+var signatureFlag = flag.Bool("signature", false, "print the signature of the function called by the enclosing call expression")
+var batchFlag = flag.Bool("batch", false, "answer newline-delimited JSON queries read from standard input")
+var lspFlag = flag.Bool("lsp", false, "speak the Language Server Protocol on standard input and output")
+var serveFlag = flag.Bool("serve", false, "run as a server answering queries on the -socket address")
+var tagsFlag = flag.String("tags", "", "comma-separated list of build tags to apply")
+var goosFlag = flag.String("goos", "", "target operating system (default $GOOS)")
+var goarchFlag = flag.String("goarch", "", "target architecture (default $GOARCH)")
+var allConfigsFlag = flag.Bool("all-configs", false, "print the definition under each of the build configurations given by -configs")
+var configsFlag = flag.String("configs", defaultConfigs, "comma-separated build configurations used by -all-configs, each goos/goarch[:tag+tag...]")
+var socketFlag = flag.String("socket", defaultSocket(), "Unix socket of the godef server; empty to never use a server")
 
 var cpuprofile = flag.String("cpuprofile", "", "write CPU profile to this file")
 var memprofile = flag.String("memprofile", "", "write memory profile to this file")
@@ -59,6 +80,7 @@ func run(ctx context.Context) error {
 	debugpkg.SetGCPercent(1600)
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "usage: godef [flags] [expr]\n")
+		fmt.Fprintf(os.Stderr, "       godef -batch|-lsp|-serve [flags]\n")
 		flag.PrintDefaults()
 	}
 	flag.Parse()
@@ -106,10 +128,73 @@ func run(ctx context.Context) error {
 		}()
 	}
 
+	if err := checkModes("serve", "batch", "lsp"); err != nil {
+		return err
+	}
+	if *serveFlag {
+		return serve(ctx, *socketFlag)
+	}
+	if *lspFlag {
+		return serveLSP(ctx, os.Stdin, os.Stdout)
+	}
+	if *batchFlag {
+		return batch(ctx, "", nil, os.Stdin, os.Stdout)
+	}
+	stdin := io.Reader(os.Stdin)
+	if !*acmeFlag {
+		dir, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		req := &serverRequest{
+			Args: os.Args[1:],
+			Dir:  dir,
+			Env:  goEnv(os.Environ()),
+		}
+		if *readStdin || *modifiedFlag {
+			req.Stdin, _ = ioutil.ReadAll(os.Stdin)
+			stdin = bytes.NewReader(req.Stdin)
+		}
+		if ok, err := forward(*socketFlag, req, os.Stdout); ok {
+			return err
+		}
+	}
+	return query(ctx, "", nil, stdin, os.Stdout)
+}
+
+// query answers the query described by the command line flags, writing
+// the result to out. Relative filenames are interpreted relative to dir,
+// and the go command is run with the environment env; if either is
+// empty, the process's own is used.
+func query(ctx context.Context, dir string, env []string, stdin io.Reader, out io.Writer) error {
+	if err := checkModes(queryModes...); err != nil {
+		return err
+	}
 	types.Debug = *debug
 	*tflag = *tflag || *aflag || *Aflag
 	searchpos := *offset
 	filename := *fflag
+	var line, col int
+	if *posFlag != "" {
+		var err error
+		if filename, line, col, err = parsePos(*posFlag); err != nil {
+			return err
+		}
+	}
+	if dir != "" && filename != "" && !filepath.IsAbs(filename) {
+		filename = filepath.Join(dir, filename)
+	}
+
+	var overlay map[string][]byte
+	if *modifiedFlag {
+		if *readStdin {
+			return fmt.Errorf("-i and -modified cannot be used together")
+		}
+		var err error
+		if overlay, err = readOverlay(stdin, dir); err != nil {
+			return err
+		}
+	}
 
 	var afile *acmeFile
 	var src []byte
@@ -120,7 +205,9 @@ func run(ctx context.Context) error {
 		}
 		filename, src, searchpos = afile.name, afile.body, afile.offset
 	} else if *readStdin {
-		src, _ = ioutil.ReadAll(os.Stdin)
+		src, _ = ioutil.ReadAll(stdin)
+	} else if data, ok := overlay[absPath(dir, filename)]; ok {
+		src = data
 	} else {
 		// TODO if there's no filename, look in the current
 		// directory and do something plausible.
@@ -130,25 +217,115 @@ func run(ctx context.Context) error {
 		}
 		src = b
 	}
+	if *posFlag != "" {
+		var err error
+		if searchpos, err = lineColOffset(filename, src, line, col, *unitsFlag); err != nil {
+			return err
+		}
+	}
 	// Load, parse, and type-check the packages named on the command line.
 	cfg := &packages.Config{
 		Context: ctx,
+		Dir:     dir,
+		Env:     env,
 		Tests:   strings.HasSuffix(filename, "_test.go"),
+		Overlay: overlay,
+	}
+	// Each of the configurations of -all-configs
+	// is applied to the unconfigured cfg.
+	base := *cfg
+	configureBuild(cfg)
+	if *refsFlag {
+		refs, err := godefRefs(cfg, filename, src, searchpos)
+		if err != nil {
+			return err
+		}
+		return printPositions(out, refs)
 	}
-	obj, err := adaptGodef(cfg, filename, src, searchpos)
+	if *implFlag {
+		impls, err := godefImpl(cfg, filename, src, searchpos)
+		if err != nil {
+			return err
+		}
+		return printPositions(out, impls)
+	}
+	if *callersFlag {
+		calls, err := godefCallers(cfg, filename, src, searchpos)
+		if err != nil {
+			return err
+		}
+		return printCalls(out, calls)
+	}
+	if *completeFlag {
+		candidates, err := godefComplete(cfg, filename, src, searchpos)
+		if err != nil {
+			return err
+		}
+		return printCandidates(out, candidates)
+	}
+	if *signatureFlag {
+		sig, err := godefSignature(cfg, filename, src, searchpos)
+		if err != nil {
+			return err
+		}
+		return printSignature(out, sig)
+	}
+	if *allConfigsFlag {
+		configs, err := parseConfigs(*configsFlag)
+		if err != nil {
+			return err
+		}
+		defs, err := godefAllConfigs(&base, configs, filename, src, searchpos, flag.Arg(0), flagOptions())
+		if err != nil {
+			return err
+		}
+		return printConfigDefs(out, defs)
+	}
+	if *calleesFlag {
+		calls, err := godefCallees(cfg, filename, src, searchpos)
+		if err != nil {
+			return err
+		}
+		return printCalls(out, calls)
+	}
+	obj, err := adaptGodef(cfg, filename, src, searchpos, flag.Arg(0), flagOptions())
 	if err != nil {
 		return err
 	}
 
 	// print old source location to facilitate backtracking
 	if *acmeFlag {
-		fmt.Printf("\t%s:#%d\n", afile.name, afile.runeOffset)
+		fmt.Fprintf(out, "\t%s:#%d\n", afile.name, afile.runeOffset)
 	}
 
-	return print(os.Stdout, obj)
+	return print(out, obj)
 }
 
-func godef(filename string, src []byte, searchpos int) (*ast.Object, types.Type, error) {
+// queryModes names the flags that each ask for a different
+// kind of answer to a query instead of the definition.
+var queryModes = []string{"refs", "impl", "callers", "callees", "complete", "signature", "all-configs"}
+
+// checkModes returns an error if more than one of the named
+// boolean flags is set.
+func checkModes(names ...string) error {
+	var set []string
+	for _, name := range names {
+		if flag.Lookup(name).Value.String() == "true" {
+			set = append(set, "-"+name)
+		}
+	}
+	if len(set) > 1 {
+		return fmt.Errorf("%s and %s cannot be used together", set[0], set[1])
+	}
+	return nil
+}
+
+// godef returns the object referred to by expr, or if expr is empty, by
+// the identifier at searchpos, along with its type. The contents of other
+// files in the package are taken from overlay in preference to the disk.
+// Unless opts asks for type information, those files are only read if
+// the identifier is not declared in filename.
+func godef(filename string, src []byte, searchpos int, expr string, overlay map[string][]byte, opts godefOptions) (*ast.Object, types.Type, error) {
 	pkgScope := ast.NewScope(parser.Universe)
 	f, err := parser.ParseFile(types.FileSet, filename, src, 0, pkgScope, types.DefaultImportPathToName)
 	if f == nil {
@@ -157,8 +334,8 @@ func godef(filename string, src []byte, searchpos int) (*ast.Object, types.Type,
 
 	var o ast.Node
 	switch {
-	case flag.NArg() > 0:
-		o, err = parseExpr(f.Scope, flag.Arg(0))
+	case expr != "":
+		o, err = parseExpr(f.Scope, expr)
 		if err != nil {
 			return nil, types.Type{}, err
 		}
@@ -178,27 +355,27 @@ func godef(filename string, src []byte, searchpos int) (*ast.Object, types.Type,
 		if err != nil {
 			return nil, types.Type{}, err
 		}
-		pkg, err := build.Default.Import(path, filepath.Dir(filename), build.FindOnly)
+		pkg, err := types.FindPackage(path, filepath.Dir(filename), build.FindOnly)
 		if err != nil {
 			return nil, types.Type{}, fmt.Errorf("error finding import path for %s: %s", path, err)
 		}
 		return &ast.Object{Kind: ast.Pkg, Data: pkg.Dir}, types.Type{}, nil
 	case ast.Expr:
-		if !*tflag {
+		if !opts.typeInfo && !opts.typeDef {
 			// try local declarations only
 			if obj, typ := types.ExprType(e, types.DefaultImporter, types.FileSet); obj != nil {
 				return obj, typ, nil
 			}
 		}
 		// add declarations from other files in the local package and try again
-		pkg, err := parseLocalPackage(filename, f, pkgScope, types.DefaultImportPathToName)
-		if pkg == nil && !*tflag {
-			fmt.Printf("parseLocalPackage error: %v\n", err)
+		pkg, err := parseLocalPackage(filename, f, pkgScope, types.DefaultImportPathToName, overlay)
+		if pkg == nil && !opts.typeInfo && !opts.typeDef {
+			fmt.Fprintf(os.Stderr, "parseLocalPackage error: %v\n", err)
 		}
-		if flag.NArg() > 0 {
+		if expr != "" {
 			// Reading declarations in other files might have
 			// resolved the original expression.
-			e, err = parseExpr(f.Scope, flag.Arg(0))
+			e, err = parseExpr(f.Scope, expr)
 			if err != nil {
 				return nil, types.Type{}, err
 			}
@@ -321,6 +498,7 @@ type Object struct {
 	Members  []*Object
 	Type     interface{}
 	Value    interface{}
+	Doc      string
 }
 
 type orderedObjects []*Object
@@ -330,34 +508,123 @@ func (o orderedObjects) Len() int           { return len(o) }
 func (o orderedObjects) Swap(i, j int)      { o[i], o[j] = o[j], o[i] }
 
 func print(out io.Writer, obj *Object) error {
-	if obj.Kind == PathKind {
-		fmt.Fprintf(out, "%s\n", obj.Value)
-		return nil
-	}
 	if *jsonFlag {
-		jsonStr, err := json.Marshal(obj.Position)
+		jsonStr, err := json.Marshal(newJSONObject(obj))
 		if err != nil {
 			return fmt.Errorf("JSON marshal error: %v", err)
 		}
 		fmt.Fprintf(out, "%s\n", jsonStr)
 		return nil
-	} else {
-		fmt.Fprintf(out, "%v\n", obj.Position)
 	}
-	if obj.Kind == BadKind || !*tflag {
+	if obj.Kind == PathKind {
+		fmt.Fprintf(out, "%s\n", obj.Value)
 		return nil
 	}
-	fmt.Fprintf(out, "%s\n", typeStr(obj))
-	if *aflag || *Aflag {
-		for _, obj := range obj.Members {
-			// Ignore unexported members unless Aflag is set.
-			if !*Aflag && (obj.Pkg != "" || !ast.IsExported(obj.Name)) {
-				continue
-			}
+	fmt.Fprintf(out, "%v\n", obj.Position)
+	if obj.Kind != BadKind && *tflag {
+		fmt.Fprintf(out, "%s\n", typeStr(obj))
+		for _, obj := range members(obj) {
 			fmt.Fprintf(out, "\t%s\n", strings.Replace(typeStr(obj), "\n", "\n\t\t", -1))
 			fmt.Fprintf(out, "\t\t%v\n", obj.Position)
 		}
 	}
+	if *docFlag {
+		fmt.Fprint(out, obj.Doc)
+	}
+	return nil
+}
+
+// members returns the members of obj to print: none unless -a or -A
+// is given, and only exported ones unless -A is given.
+func members(obj *Object) []*Object {
+	if !*aflag && !*Aflag {
+		return nil
+	}
+	var result []*Object
+	for _, obj := range obj.Members {
+		// Ignore unexported members unless Aflag is set. The
+		// members found by the legacy implementation have no Pkg,
+		// so they are filtered by name alone, as they always were.
+		if !*Aflag && !ast.IsExported(obj.Name) {
+			continue
+		}
+		result = append(result, obj)
+	}
+	return result
+}
+
+// jsonVersion is the version of the -json output schema. It changes
+// when a field is removed or changes meaning, but not when one is added.
+const jsonVersion = 1
+
+// jsonObject is the form in which -json prints an Object. See the
+// package documentation for a description of the fields.
+type jsonObject struct {
+	Position
+	Version int           `json:"version,omitempty"`
+	Name    string        `json:"name,omitempty"`
+	Kind    Kind          `json:"kind"`
+	Pkg     string        `json:"pkg,omitempty"`
+	Type    string        `json:"type,omitempty"`
+	Value   string        `json:"value,omitempty"`
+	Doc     string        `json:"doc,omitempty"`
+	Members []*jsonObject `json:"members,omitempty"`
+}
+
+// newJSONObject returns the JSON form of obj, with its
+// members if -a or -A is given and its doc comment if -doc is.
+func newJSONObject(obj *Object) *jsonObject {
+	j := jsonMember(obj)
+	j.Version = jsonVersion
+	if *docFlag {
+		j.Doc = obj.Doc
+	}
+	for _, m := range members(obj) {
+		j.Members = append(j.Members, jsonMember(m))
+	}
+	return j
+}
+
+// jsonMember returns the JSON form of obj without its members.
+func jsonMember(obj *Object) *jsonObject {
+	j := &jsonObject{
+		Position: obj.Position,
+		Name:     obj.Name,
+		Kind:     obj.Kind,
+		Pkg:      obj.Pkg,
+	}
+	switch obj.Kind {
+	case PathKind:
+		j.Value = fmt.Sprint(obj.Value)
+	case BadKind:
+	default:
+		if obj.Type != nil {
+			j.Type = fmt.Sprint(pretty{obj.Type})
+		}
+		if obj.Value != nil {
+			j.Value = fmt.Sprint(pretty{obj.Value})
+		}
+	}
+	return j
+}
+
+// printPositions prints each position on its own line,
+// or as a single array when -json is given.
+func printPositions(out io.Writer, positions []Position) error {
+	if *jsonFlag {
+		if positions == nil {
+			positions = []Position{}
+		}
+		jsonStr, err := json.Marshal(positions)
+		if err != nil {
+			return fmt.Errorf("JSON marshal error: %v", err)
+		}
+		fmt.Fprintf(out, "%s\n", jsonStr)
+		return nil
+	}
+	for _, pos := range positions {
+		fmt.Fprintf(out, "%v\n", pos)
+	}
 	return nil
 }
 
@@ -425,7 +692,7 @@ var errNoPkgFiles = errors.New("no more package files found")
 // current directory that implement the same package name
 // the principal source file, except the original source file
 // itself, which will already have been parsed.
-func parseLocalPackage(filename string, src *ast.File, pkgScope *ast.Scope, pathToName parser.ImportPathToName) (*ast.Package, error) {
+func parseLocalPackage(filename string, src *ast.File, pkgScope *ast.Scope, pathToName parser.ImportPathToName, overlay map[string][]byte) (*ast.Package, error) {
 	pkg := &ast.Package{src.Name.Name, pkgScope, nil, map[string]*ast.File{filename: src}}
 	d, f := filepath.Split(filename)
 	if d == "" {
@@ -441,16 +708,31 @@ func parseLocalPackage(filename string, src *ast.File, pkgScope *ast.Scope, path
 	if err != nil {
 		return nil, errNoPkgFiles
 	}
+	// Modified files may not have been saved yet.
+	for name := range overlay {
+		if pf := filepath.Base(name); filepath.Dir(name) == absPath("", d) && !slices.Contains(list, pf) {
+			list = append(list, pf)
+		}
+	}
 
 	for _, pf := range list {
 		file := filepath.Join(d, pf)
-		if !strings.HasSuffix(pf, ".go") ||
-			pf == f ||
-			pkgName(file) != pkg.Name {
+		if !strings.HasSuffix(pf, ".go") || pf == f {
+			continue
+		}
+		if match, err := types.BuildContext.MatchFile(d, pf); err == nil && !match {
+			continue
+		}
+		var data interface{}
+		if b, ok := overlay[absPath("", file)]; ok {
+			data = b
+		}
+		if pkgName(file, data) != pkg.Name {
 			continue
 		}
-		src, err := parser.ParseFile(types.FileSet, file, nil, 0, pkg.Scope, types.DefaultImportPathToName)
-		if err == nil {
+		// Files with syntax errors still provide
+		// the declarations that could be parsed.
+		if src, _ := parser.ParseFile(types.FileSet, file, data, 0, pkg.Scope, types.DefaultImportPathToName); src != nil {
 			pkg.Files[file] = src
 		}
 	}
@@ -461,9 +743,9 @@ func parseLocalPackage(filename string, src *ast.File, pkgScope *ast.Scope, path
 }
 
 // pkgName returns the package name implemented by the
-// go source filename.
-func pkgName(filename string) string {
-	prog, _ := parser.ParseFile(types.FileSet, filename, nil, parser.PackageClauseOnly, nil, types.DefaultImportPathToName)
+// go source filename, whose contents are src if non-nil.
+func pkgName(filename string, src interface{}) string {
+	prog, _ := parser.ParseFile(types.FileSet, filename, src, parser.PackageClauseOnly, nil, types.DefaultImportPathToName)
 	if prog != nil {
 		return prog.Name.Name
 	}
@@ -1,35 +1,47 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"go/build"
 	"go/token"
+	"io"
 	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"golang.org/x/tools/go/packages"
 	"golang.org/x/tools/go/packages/packagestest"
+
+	"github.com/bobg/godef/go/types"
 )
 
+// testdataModules holds the module exported for the tests.
+var testdataModules = []packagestest.Module{{
+	Name:  "github.com/bobg/godef",
+	Files: packagestest.MustCopyFileTree("testdata"),
+}}
+
 func TestGoDef(t *testing.T) { packagestest.TestAll(t, testGoDef) }
 func testGoDef(t *testing.T, exporter packagestest.Exporter) {
-	runGoDefTest(t, exporter, 1, []packagestest.Module{{
-		Name:  "github.com/bobg/godef",
-		Files: packagestest.MustCopyFileTree("testdata"),
-	}})
+	runGoDefTest(t, exporter, 1, testdataModules)
 }
 
 func BenchmarkGoDef(b *testing.B) { packagestest.BenchmarkAll(b, benchGoDef) }
 func benchGoDef(b *testing.B, exporter packagestest.Exporter) {
-	runGoDefTest(b, exporter, b.N, []packagestest.Module{{
-		Name:  "github.com/bobg/godef",
-		Files: packagestest.MustCopyFileTree("testdata"),
-	}})
+	runGoDefTest(b, exporter, b.N, testdataModules)
 }
 
 func runGoDefTest(t testing.TB, exporter packagestest.Exporter, runCount int, modules []packagestest.Module) {
@@ -39,7 +51,30 @@ func runGoDefTest(t testing.TB, exporter packagestest.Exporter, runCount int, mo
 	posStr := func(p token.Position) string {
 		return localPos(p, exported, modules)
 	}
+	checkPositions := func(t testing.TB, what string, src token.Position, positions []Position, want []token.Position) {
+		var got, expect []string
+		for _, pos := range positions {
+			got = append(got, posStr(token.Position{
+				Filename: pos.Filename,
+				Line:     pos.Line,
+				Column:   pos.Column,
+			}))
+		}
+		for _, pos := range want {
+			expect = append(expect, posStr(pos))
+		}
+		sort.Strings(got)
+		sort.Strings(expect)
+		if strings.Join(got, "\n") != strings.Join(expect, "\n") {
+			t.Errorf("%s of %v: got %v expected %v", what, posStr(src), got, expect)
+		}
+	}
 
+	// The exported environment is used by the legacy implementation
+	// for the duration of the test only.
+	defer func(gopath, goroot string) {
+		build.Default.GOPATH, build.Default.GOROOT = gopath, goroot
+	}(build.Default.GOPATH, build.Default.GOROOT)
 	const gopathPrefix = "GOPATH="
 	const gorootPrefix = "GOROOT="
 	for _, v := range exported.Config.Env {
@@ -69,14 +104,40 @@ func runGoDefTest(t testing.TB, exporter packagestest.Exporter, runCount int, mo
 				t.Errorf("Got %v expected %v", posStr(check), posStr(target))
 			}
 		},
-		"godefPrint": func(src token.Position, mode string, re *regexp.Regexp) {
+		"godefType": func(src, target token.Position) {
 			count++
+			*typeDefFlag = true
+			defer func() { *typeDefFlag = false }()
 			obj, err := invokeGodef(exported.Config, src, runCount)
 			if err != nil {
 				t.Error(err)
 				return
 			}
-			buf := &bytes.Buffer{}
+			check := token.Position{
+				Filename: obj.Position.Filename,
+				Line:     obj.Position.Line,
+				Column:   obj.Position.Column,
+			}
+			if posStr(check) != posStr(target) {
+				t.Errorf("Got type %v expected %v", posStr(check), posStr(target))
+			}
+		},
+		"godefBuiltinType": func(src token.Position, name string) {
+			count++
+			*typeDefFlag = true
+			defer func() { *typeDefFlag = false }()
+			obj, err := invokeGodef(exported.Config, src, runCount)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if want := predeclaredPos("builtin", name); obj.Position.Filename == "" || obj.Position != want {
+				t.Errorf("Got type %v expected %v", obj.Position, want)
+			}
+		},
+		"godefPrint": func(src token.Position, mode string, re *regexp.Regexp) {
+			count++
+			*docFlag = false
 			switch mode {
 			case "json":
 				*jsonFlag = true
@@ -98,15 +159,68 @@ func runGoDefTest(t testing.TB, exporter packagestest.Exporter, runCount int, mo
 				*tflag = true
 				*aflag = false
 				*Aflag = false
+			case "doc":
+				*jsonFlag = false
+				*tflag = false
+				*aflag = false
+				*Aflag = false
+				*docFlag = true
+			case "jsondoc":
+				*jsonFlag = true
+				*tflag = false
+				*aflag = false
+				*Aflag = false
+				*docFlag = true
 			default:
 				t.Fatalf("Invalid print mode %v", mode)
 			}
-
+			obj, err := invokeGodef(exported.Config, src, runCount)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			buf := &bytes.Buffer{}
 			print(buf, obj)
 			if !re.Match(buf.Bytes()) {
 				t.Errorf("in mode %q got %v want %v", mode, buf, re)
 			}
 		},
+		"godefRefs": func(src token.Position, want []token.Position) {
+			count++
+			refs, err := invokePositions(godefRefs, exported.Config, src)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			checkPositions(t, "references", src, refs, want)
+		},
+		"godefImpl": func(src token.Position, want []token.Position) {
+			count++
+			impls, err := invokePositions(godefImpl, exported.Config, src)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			checkPositions(t, "implementations", src, impls, want)
+		},
+		"godefCallers": func(src token.Position, want []token.Position) {
+			count++
+			calls, err := invokeCalls(godefCallers, exported.Config, src)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			checkPositions(t, "callers", src, calls, want)
+		},
+		"godefCallees": func(src token.Position, want []token.Position) {
+			count++
+			calls, err := invokeCalls(godefCallees, exported.Config, src)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			checkPositions(t, "callees", src, calls, want)
+		},
 	}); err != nil {
 		t.Fatal(err)
 	}
@@ -115,8 +229,695 @@ func runGoDefTest(t testing.TB, exporter packagestest.Exporter, runCount int, mo
 	}
 }
 
+func TestServer(t *testing.T) {
+	exported := exportTestdata(t, packagestest.Modules)
+
+	// The server makes the socket's directory, which only the user can use.
+	socket := filepath.Join(t.TempDir(), "godef", "godef.sock")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- serve(ctx, socket) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+		pkgCache = nil
+		resetFlags()
+	}()
+	for i := 0; ; i++ {
+		if _, err := os.Stat(socket); err == nil {
+			break
+		}
+		if i == 100 {
+			t.Fatal("server did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_, input := readTestdata(t, exported, "a/a.go")
+	offset := bytes.Index(input, []byte("Random2(x)"))
+	want := exported.File("github.com/bobg/godef", "a/random.go") + ":8:6\n"
+	// The second query is answered from the cache.
+	for i := 0; i < 2; i++ {
+		req := &serverRequest{
+			Args: []string{"-f", "a/a.go", "-o", strconv.Itoa(offset)},
+			Dir:  exported.Config.Dir,
+			Env:  exported.Config.Env,
+		}
+		out := &bytes.Buffer{}
+		ok, err := forward(socket, req, out)
+		if !ok || err != nil {
+			t.Fatalf("query %d: forwarded %v, error %v", i, ok, err)
+		}
+		if out.String() != want {
+			t.Errorf("query %d: got %q want %q", i, out, want)
+		}
+	}
+
+	req := &serverRequest{
+		Args: []string{"-no-such-flag"},
+		Dir:  exported.Config.Dir,
+	}
+	if ok, err := forward(socket, req, ioutil.Discard); !ok || err == nil {
+		t.Errorf("bad flag: forwarded %v, error %v", ok, err)
+	}
+}
+
+func TestConflictingModes(t *testing.T) {
+	defer resetFlags()
+	for _, test := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-callees", "-callers"}, "-callers and -callees cannot be used together"},
+		{[]string{"-all-configs", "-refs"}, "-refs and -all-configs cannot be used together"},
+	} {
+		args := append(test.args, "-f", "a.go", "-o", "0")
+		if resp := answer(context.Background(), &serverRequest{Args: args}); resp.Error != test.want {
+			t.Errorf("%v: got error %q want %q", test.args, resp.Error, test.want)
+		}
+	}
+}
+
+func TestServerSocket(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skipf("file permissions are not checked on %s", runtime.GOOS)
+	}
+	dir := t.TempDir()
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(dir, "godef.sock")
+	if err := serve(context.Background(), socket); err == nil {
+		t.Errorf("server listening in a directory others can use")
+	}
+	// Nothing is sent to a socket in such a directory,
+	// even if something is listening on it.
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if ok, err := forward(socket, &serverRequest{}, ioutil.Discard); ok || err != nil {
+		t.Errorf("forwarded %v, error %v", ok, err)
+	}
+
+	env := goEnv([]string{"GOPATH=/go", "GO111MODULE=on", "CGO_ENABLED=0", "GOOGLE_TOKEN=secret", "HOME=/home", "CC=cc"})
+	if got, want := strings.Join(env, " "), "GOPATH=/go GO111MODULE=on CGO_ENABLED=0"; got != want {
+		t.Errorf("forwarded environment %q want %q", got, want)
+	}
+}
+
+func TestLSP(t *testing.T) {
+	exported := exportTestdata(t, packagestest.Modules)
+
+	filename, input := readTestdata(t, exported, "a/a.go")
+	uri := filenameToURI(filename)
+	pos := lspPos(input, bytes.Index(input, []byte("Random2(x)")))
+	in := &bytes.Buffer{}
+	send := func(id int, method string, params interface{}) {
+		msg := map[string]interface{}{
+			"jsonrpc": "2.0",
+			"method":  method,
+			"params":  params,
+		}
+		if id > 0 {
+			msg["id"] = id
+		}
+		data, err := json.Marshal(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(in, "Content-Length: %d\r\n\r\n%s", len(data), data)
+	}
+	position := map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+		"position":     pos,
+	}
+	send(1, "initialize", map[string]string{"rootUri": filenameToURI(exported.Config.Dir)})
+	send(0, "initialized", map[string]string{})
+	send(0, "textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri, "text": string(input)},
+	})
+	send(2, "textDocument/definition", position)
+	send(3, "textDocument/hover", position)
+	send(4, "textDocument/formatting", position)
+	random, randomSrc := readTestdata(t, exported, "a/random.go")
+	send(6, "textDocument/typeDefinition", map[string]interface{}{
+		"textDocument": map[string]string{"uri": filenameToURI(random)},
+		"position":     lspPos(randomSrc, bytes.Index(randomSrc, []byte("p.Sum()"))),
+	})
+	send(5, "shutdown", nil)
+	send(0, "exit", nil)
+
+	// The answers do not depend on the command line flags.
+	*typeDefFlag, *docFlag = true, true
+	defer func() { *typeDefFlag, *docFlag = false, false }()
+	out := &bytes.Buffer{}
+	if err := serveLSP(context.Background(), in, out); err != nil {
+		t.Fatal(err)
+	}
+	responses := make(map[int]lspResponse)
+	r := bufio.NewReader(out)
+	for {
+		body, err := readLSPMessage(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var resp lspResponse
+		if err := json.Unmarshal(body, &resp); err != nil {
+			t.Fatal(err)
+		}
+		id, _ := strconv.Atoi(string(resp.ID))
+		responses[id] = resp
+	}
+
+	var locs []lspLocation
+	if err := json.Unmarshal(responses[2].Result, &locs); err != nil {
+		t.Fatalf("definition: %v (%+v)", err, responses[2])
+	}
+	wantURI := filenameToURI(exported.File("github.com/bobg/godef", "a/random.go"))
+	wantRange := lspRange{Start: lspPosition{7, 5}, End: lspPosition{7, 12}}
+	if len(locs) != 1 || locs[0].URI != wantURI || locs[0].Range != wantRange {
+		t.Errorf("definition: got %+v want %v %v", locs, wantURI, wantRange)
+	}
+	if !bytes.Contains(responses[3].Result, []byte("Random2 func(y int) int")) {
+		t.Errorf("hover: got %s", responses[3].Result)
+	}
+	if responses[4].Error == nil || responses[4].Error.Code != lspMethodNotFound {
+		t.Errorf("unsupported method: got %+v", responses[4])
+	}
+	if string(responses[5].Result) != "null" {
+		t.Errorf("shutdown: got %+v", responses[5])
+	}
+	locs = nil
+	if err := json.Unmarshal(responses[6].Result, &locs); err != nil {
+		t.Fatalf("typeDefinition: %v (%+v)", err, responses[6])
+	}
+	wantRange = lspRange{Start: lspPosition{11, 5}, End: lspPosition{11, 8}}
+	if len(locs) != 1 || locs[0].URI != wantURI || locs[0].Range != wantRange {
+		t.Errorf("typeDefinition: got %+v want %v %v", locs, wantURI, wantRange)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	exported := exportTestdata(t, packagestest.Modules)
+	defer func() { pkgCache = nil }()
+
+	_, input := readTestdata(t, exported, "a/a.go")
+	random := exported.File("github.com/bobg/godef", "a/random.go")
+	in := strings.NewReader(fmt.Sprintf(`{"id": 1, "file": "a/a.go", "offset": %d}
+{"id": "two", "file": "a/a.go", "offset": %d}
+{"id": 3, "file": "a/a.go", "expr": "Random2"}
+
+{"file": "a/missing.go", "offset": 0}
+not json
+`, bytes.Index(input, []byte("Random2(x)")), bytes.Index(input, []byte("Random()"))))
+	out := &bytes.Buffer{}
+	if err := batch(context.Background(), exported.Config.Dir, exported.Config.Env, in, out); err != nil {
+		t.Fatal(err)
+	}
+	var results []batchResult
+	dec := json.NewDecoder(out)
+	for dec.More() {
+		var result batchResult
+		if err := dec.Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, result)
+	}
+	if len(results) != 5 {
+		t.Fatalf("got %d results want 5", len(results))
+	}
+	for i, want := range []struct {
+		id   string
+		line int
+	}{{"1", 8}, {`"two"`, 3}, {"3", 8}} {
+		r := results[i]
+		if string(r.ID) != want.id || r.Position == nil || r.Position.Filename != random || r.Position.Line != want.line {
+			t.Errorf("result %d: got %+v want %s:%d", i, r, random, want.line)
+		}
+	}
+	for _, r := range results[3:] {
+		if r.Error == "" {
+			t.Errorf("expected error, got %+v", r)
+		}
+	}
+	if len(pkgCache.entries) != 1 {
+		t.Errorf("got %d cache entries want 1", len(pkgCache.entries))
+	}
+}
+
+func TestCacheFiles(t *testing.T) {
+	exported := exportTestdata(t, packagestest.Modules)
+
+	// The package imports fmt, whose files are
+	// not checked for changes.
+	filename, src := readTestdata(t, exported, "broken/unclosedIf.go")
+	cfg := *exported.Config
+	c := newPackageCache()
+	if _, _, err := c.loadObject(&cfg, filename, src, bytes.Index(src, []byte("Println"))); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range c.entries {
+		for name := range e.modTimes {
+			if !strings.HasPrefix(name, exported.Temp()) {
+				t.Errorf("cache entry depends on %s", name)
+			}
+		}
+		if !e.valid(cfg.Overlay, filename) {
+			t.Errorf("cache entry is not valid")
+		}
+	}
+}
+
+func TestMembers(t *testing.T) {
+	exported := exportTestdata(t, packagestest.Modules)
+	defer func() { *aflag, *Aflag = false, false }()
+	defer func() { forcePackages = unset }()
+
+	filename, src := readTestdata(t, exported, "b/b.go")
+	// Both implementations leave out the same unexported members, but
+	// the legacy one also lists the promoted field that S1.F1 shadows.
+	for _, test := range []struct {
+		impl triBool
+		all  bool
+		want string
+	}{
+		{on, false, "F1:6 F2:14 Method:26 S2:9"},
+		{on, true, "F1:6 F2:14 Method:26 S2:9 f2:7 f3:8"},
+		{off, false, "F1:6 F1:13 F2:14 Method:26 S2:9"},
+		{off, true, "F1:6 F1:13 F2:14 Method:26 S2:9 f2:7 f3:8"},
+	} {
+		forcePackages = test.impl
+		*aflag, *Aflag = !test.all, test.all
+		obj, err := adaptGodef(exported.Config, filename, src, bytes.Index(src, []byte("S1  //")), "", godefOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, m := range members(obj) {
+			if m.Position.Filename != filename {
+				t.Errorf("member %s is in %s, want %s", m.Name, m.Position.Filename, filename)
+			}
+			got = append(got, fmt.Sprintf("%s:%d", m.Name, m.Position.Line))
+		}
+		if strings.Join(got, " ") != test.want {
+			t.Errorf("with -A=%v -new-implementation=%v got members %v want %v", test.all, &forcePackages, got, test.want)
+		}
+	}
+}
+
+func TestComplete(t *testing.T) {
+	exported := exportTestdata(t, packagestest.Modules)
+
+	filename, src := readTestdata(t, exported, "b/b.go")
+	for _, test := range []struct {
+		after string
+		want  string
+	}{
+		{"s1.", "F1 F2 Method S2 f2 f3"},
+		{"s2.F", "F1 F2"},
+		{"a.St", "Stuff"},
+		{"S", "S1 S2"},
+		{"se", "second"},
+	} {
+		input := append(src, fmt.Sprintf("\nfunc complete(s1 S1, s2 *S2) {\n\tsecond := 2\n\t%s\n\t_ = second\n}\n", test.after)...)
+		searchpos := bytes.Index(input, []byte("\t"+test.after+"\n")) + 1 + len(test.after)
+		candidates, err := godefComplete(exported.Config, filename, input, searchpos)
+		if err != nil {
+			t.Errorf("completing %q: %v", test.after, err)
+			continue
+		}
+		var got []string
+		for _, c := range candidates {
+			got = append(got, c.Name)
+		}
+		if strings.Join(got, " ") != test.want {
+			t.Errorf("completing %q got %v want %v", test.after, got, test.want)
+		}
+	}
+}
+
+func TestSignature(t *testing.T) {
+	exported := exportTestdata(t, packagestest.Modules)
+
+	filename, src := readTestdata(t, exported, "a/random.go")
+	src = append(src, "\n// join joins parts with sep.\nfunc join(sep string, parts ...int) {}\n"...)
+	for _, test := range []struct {
+		call   string
+		want   string
+		active int
+		doc    string
+	}{
+		{"join(", "join(sep string, parts ...int)", 0, "join joins parts with sep.\n"},
+		{`join(",", Random2(1), 2, `, "join(sep string, parts ...int)", 1, "join joins parts with sep.\n"},
+		{`join(",", Random2(`, "Random2(y int) int", 0, ""},
+		{"p.Sum(", "(*Pos).Sum() int", 0, ""},
+	} {
+		input := append(src, fmt.Sprintf("\nfunc signature(p *Pos) {\n\t%s\n}\n", test.call)...)
+		searchpos := bytes.LastIndex(input, []byte(test.call)) + len(test.call)
+		sig, err := godefSignature(exported.Config, filename, input, searchpos)
+		if err != nil {
+			t.Errorf("signature in %q: %v", test.call, err)
+			continue
+		}
+		if sig.Signature != test.want || sig.Active != test.active || sig.Doc != test.doc {
+			t.Errorf("signature in %q: got %q, active %d, doc %q; want %q, active %d, doc %q",
+				test.call, sig.Signature, sig.Active, sig.Doc, test.want, test.active, test.doc)
+		}
+	}
+	// Builtin functions are described by their declarations in package
+	// builtin, even when the call is evaluated as a constant.
+	for _, test := range []struct {
+		call, rest string
+		want       string
+		active     int
+	}{
+		{"_ = append([]int{}, 1, ", "2)", "append(slice []Type, elems ...Type) []Type", 1},
+		{"_ = max(1, ", "2)", "max[T cmp.Ordered](x T, y ...T) T", 1},
+	} {
+		input := append(src, fmt.Sprintf("\nfunc signature() {\n\t%s%s\n}\n", test.call, test.rest)...)
+		searchpos := bytes.LastIndex(input, []byte(test.call)) + len(test.call)
+		sig, err := godefSignature(exported.Config, filename, input, searchpos)
+		if err != nil {
+			t.Errorf("signature in %q: %v", test.call, err)
+			continue
+		}
+		name := test.want[:strings.IndexAny(test.want, "[(")]
+		if sig.Signature != test.want || sig.Active != test.active || sig.Position.Filename == "" || sig.Position != predeclaredPos("builtin", name) {
+			t.Errorf("signature in %q: got %q, active %d, at %v; want %q, active %d, at the declaration of %s in package builtin",
+				test.call, sig.Signature, sig.Active, sig.Position, test.want, test.active, name)
+		}
+		if !strings.HasPrefix(sig.Doc, "The "+name+" built-in function") {
+			t.Errorf("signature in %q: got doc %q", test.call, sig.Doc)
+		}
+	}
+}
+
+func TestPredeclared(t *testing.T) {
+	exported := exportTestdata(t, packagestest.Modules)
+	defer func() { forcePackages = unset }()
+
+	filename := exported.File("github.com/bobg/godef", "b/b.go")
+	src := []byte(`package b
+
+import "unsafe"
+
+func predeclared(e error) int {
+	var x any = true
+	_ = x
+	return len(e.Error()) + int(unsafe.Sizeof(e))
+}
+`)
+	for _, impl := range []triBool{on, off} {
+		forcePackages = impl
+		for _, test := range []struct {
+			name, pkg string
+		}{
+			{"error", "builtin"},
+			{"any", "builtin"},
+			{"true", "builtin"},
+			{"len", "builtin"},
+			{"Error", "builtin"},
+			{"Sizeof", "unsafe"},
+		} {
+			obj, err := adaptGodef(exported.Config, filename, src, bytes.Index(src, []byte(test.name)), "", godefOptions{})
+			if err != nil {
+				t.Errorf("%s with -new-implementation=%v: %v", test.name, &forcePackages, err)
+				continue
+			}
+			want := filepath.Join(build.Default.GOROOT, "src", test.pkg, test.pkg+".go")
+			pos := obj.Position
+			if pos.Filename != want {
+				t.Errorf("%s with -new-implementation=%v: got %v want a position in %s", test.name, &forcePackages, pos, want)
+				continue
+			}
+			data, err := ioutil.ReadFile(pos.Filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			line := strings.Split(string(data), "\n")[pos.Line-1]
+			if !strings.HasPrefix(line[pos.Column-1:], test.name) {
+				t.Errorf("%s with -new-implementation=%v: got %v, at %q", test.name, &forcePackages, pos, line)
+			}
+		}
+	}
+}
+
+func TestModified(t *testing.T) { packagestest.TestAll(t, testModified) }
+func testModified(t *testing.T, exporter packagestest.Exporter) {
+	exported := exportTestdata(t, exporter)
+	defer resetFlags()
+
+	// Neither modified file is saved: a.go gains a line, and
+	// Random2 moves down three lines in random.go.
+	filename, a := readTestdata(t, exported, "a/a.go")
+	a = append([]byte("// Unsaved.\n"), a...)
+	random, r := readTestdata(t, exported, "a/random.go")
+	r = bytes.Replace(r, []byte("package a\n"), []byte("package a\n\n\n\n"), 1)
+	archive := fmt.Sprintf("%s\n%d\n%s%s\n%d\n%s", filename, len(a), a, random, len(r), r)
+
+	args := []string{"-modified", "-f", filename, "-o", strconv.Itoa(bytes.Index(a, []byte("Random2(x)")))}
+	if err := flag.CommandLine.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	if err := query(context.Background(), exported.Config.Dir, exported.Config.Env, strings.NewReader(archive), out); err != nil {
+		t.Fatal(err)
+	}
+	if want := random + ":11:6\n"; out.String() != want {
+		t.Errorf("got %q want %q", out, want)
+	}
+}
+
+func TestBuildTags(t *testing.T) { packagestest.TestAll(t, testBuildTags) }
+func testBuildTags(t *testing.T, exporter packagestest.Exporter) {
+	exported := exportTestdata(t, exporter)
+	defer resetFlags()
+	defer func() { types.BuildContext, types.Env = &build.Default, nil }()
+
+	for _, test := range []struct {
+		flags      []string
+		file, expr string
+		want, pos  string
+	}{
+		{[]string{"-goos", "windows", "-goarch", "amd64"}, "tags/tags_windows.go", "Common()", "tags/tags.go", ":3:6"},
+		{[]string{"-tags", "integration"}, "tags/integration.go", "tagged()", "tags/tagged.go", ":5:6"},
+	} {
+		filename, src := readTestdata(t, exported, test.file)
+		resetFlags()
+		args := append(test.flags, "-f", filename, "-o", strconv.Itoa(bytes.Index(src, []byte(test.expr))))
+		if err := flag.CommandLine.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		out := &bytes.Buffer{}
+		if err := query(context.Background(), exported.Config.Dir, exported.Config.Env, nil, out); err != nil {
+			t.Errorf("%v: %v", test.flags, err)
+			continue
+		}
+		if want := exported.File("github.com/bobg/godef", test.want) + test.pos + "\n"; out.String() != want {
+			t.Errorf("%v: got %q want %q", test.flags, out, want)
+		}
+	}
+}
+
+func TestAllConfigs(t *testing.T) { packagestest.TestAll(t, testAllConfigs) }
+func testAllConfigs(t *testing.T, exporter packagestest.Exporter) {
+	exported := exportTestdata(t, exporter)
+	defer resetFlags()
+
+	filename, src := readTestdata(t, exported, "tags/tags.go")
+	configs, err := parseConfigs("linux/amd64,windows/amd64,darwin/arm64,linux/arm64:integration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defs, err := godefAllConfigs(exported.Config, configs, filename, src, bytes.Index(src, []byte("platformName")), "", godefOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, def := range defs {
+		got = append(got, fmt.Sprintf("%s:%d %s", filepath.Base(def.Filename), def.Line, strings.Join(def.Configs, " ")))
+	}
+	want := []string{
+		"tags_linux.go:3 linux/amd64 linux/arm64:integration",
+		"tags_windows.go:7 windows/amd64",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got %q want %q", got, want)
+	}
+	if types.BuildContext != &build.Default {
+		t.Errorf("build context was not restored")
+	}
+}
+
+func TestCgoEnabled(t *testing.T) {
+	defer func() { types.BuildContext, types.Env = &build.Default, nil }()
+	goos := "windows"
+	if runtime.GOOS == goos {
+		goos = "linux"
+	}
+	for _, cgo := range []string{"", "1"} {
+		cfg := &packages.Config{Env: []string{"CGO_ENABLED=" + cgo}}
+		buildConfig{goos: goos, goarch: runtime.GOARCH}.apply(cfg)
+		if got, want := types.BuildContext.CgoEnabled, cgo == "1"; got != want {
+			t.Errorf("with CGO_ENABLED=%s got CgoEnabled %v want %v", cgo, got, want)
+		}
+	}
+}
+
+func TestDetectModuleMode(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "go.work"), []byte("go 1.22\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sub := filepath.Join(dir, "a", "b")
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, gowork := range []string{"", "off"} {
+		cfg := &packages.Config{Dir: sub, Env: append(os.Environ(), "GO111MODULE=auto", "GOWORK="+gowork)}
+		if got, want := detectModuleMode(cfg), gowork != "off"; got != want {
+			t.Errorf("with GOWORK=%s got module mode %v want %v", gowork, got, want)
+		}
+	}
+}
+
+func TestModuleExpr(t *testing.T) {
+	exported := exportTestdata(t, packagestest.Modules)
+
+	filename, src := readTestdata(t, exported, "b/b.go")
+	for _, test := range []struct {
+		expr string
+		file string
+		line int
+	}{
+		{"a.Stuff", "a/a.go", 6},
+		{"S1", "b/b.go", 5},
+		{"S1{}.S2.F1", "b/b.go", 13},
+		{"(&S1{}).f3.Method", "b/b.go", 26},
+		{"[]S2{}[0].F2", "b/b.go", 14},
+	} {
+		obj, err := adaptGodef(exported.Config, filename, src, -1, test.expr, godefOptions{})
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+		want := exported.File("github.com/bobg/godef", test.file)
+		if obj.Position.Filename != want || obj.Position.Line != test.line {
+			t.Errorf("%s: got %v want %s:%d", test.expr, obj.Position, want, test.line)
+		}
+	}
+	if _, err := adaptGodef(exported.Config, filename, src, -1, "S1{}.nosuch", godefOptions{}); err == nil {
+		t.Errorf("S1{}.nosuch: expected error")
+	}
+}
+
+func TestSyntaxErrors(t *testing.T) {
+	exported := exportTestdata(t, packagestest.Modules)
+
+	filename, src := readTestdata(t, exported, "b/b.go")
+	// The syntax errors leave the lines of the file unchanged.
+	for _, broken := range []string{
+		string(src) + "\nfunc x() {\n",
+		strings.Replace(string(src), "func Bar() {", "func Bar() { if true {", 1),
+		strings.Replace(string(src), "x.F1      //", "x.F1 + * ) ] //", 1),
+	} {
+		for _, ident := range []string{"Stuff", "S1  //", "x.F2", "S2.F1", "Method"} {
+			want, err := adaptGodef(exported.Config, filename, src, bytes.Index(src, []byte(ident)), "", godefOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := adaptGodef(exported.Config, filename, []byte(broken), strings.Index(broken, ident), "", godefOptions{})
+			if err != nil {
+				t.Errorf("%s in %q: %v", ident, broken, err)
+				continue
+			}
+			if got.Name != want.Name || got.Kind != want.Kind || got.Pkg != want.Pkg || got.Position != want.Position || fmt.Sprint(got.Type) != fmt.Sprint(want.Type) {
+				t.Errorf("%s in %q: got %s %v %s %v %v want %s %v %s %v %v", ident, broken,
+					got.Name, got.Kind, got.Pkg, got.Position, got.Type,
+					want.Name, want.Kind, want.Pkg, want.Position, want.Type)
+			}
+		}
+	}
+}
+
+func TestReadOverlay(t *testing.T) {
+	overlay, err := readOverlay(strings.NewReader("a.go\n3\nabc/b/b.go\n0\n"), "/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(overlay) != 2 || string(overlay["/dir/a.go"]) != "abc" || overlay["/b/b.go"] == nil {
+		t.Errorf("got %q", overlay)
+	}
+	for _, bad := range []string{"a.go\n", "a.go\nx\n", "a.go\n4\nabc"} {
+		if _, err := readOverlay(strings.NewReader(bad), "/dir"); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
+
+func TestLineColOffset(t *testing.T) {
+	src := []byte("package p\n\nvar s = \"h\u00e9\U0001F600\" + x\n")
+	x := bytes.IndexByte(src, 'x')
+	for _, test := range []struct {
+		line, col int
+		units     string
+		want      int
+	}{
+		{1, 1, byteUnits, 0},
+		{1, 9, runeUnits, 8},
+		{1, 10, utf16Units, 9},
+		{2, 1, utf16Units, 10},
+		{3, 21, byteUnits, x},
+		{3, 17, runeUnits, x},
+		{3, 18, utf16Units, x},
+		{3, 99, runeUnits, -1},
+		{4, 1, byteUnits, len(src)},
+		{4, 1, utf16Units, len(src)},
+		{4, 2, byteUnits, -1},
+		{5, 1, byteUnits, -1},
+		{1, 1, "lines", -1},
+	} {
+		got, err := lineColOffset("p.go", src, test.line, test.col, test.units)
+		if test.want < 0 {
+			if err == nil {
+				t.Errorf("%d:%d in %s: got %d, expected error", test.line, test.col, test.units, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("%d:%d in %s: got %d, %v want %d", test.line, test.col, test.units, got, err, test.want)
+		}
+	}
+}
+
 var cwd, _ = os.Getwd()
 
+// exportTestdata exports testdataModules with exporter
+// for the duration of the test.
+func exportTestdata(t testing.TB, exporter packagestest.Exporter) *packagestest.Exported {
+	exported := packagestest.Export(t, exporter, testdataModules)
+	t.Cleanup(exported.Cleanup)
+	return exported
+}
+
+// readTestdata returns the name and contents of the
+// exported file at the given path in the module.
+func readTestdata(t testing.TB, exported *packagestest.Exported, fragment string) (string, []byte) {
+	filename := exported.File("github.com/bobg/godef", fragment)
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filename, src
+}
+
 func invokeGodef(cfg *packages.Config, src token.Position, runCount int) (*Object, error) {
 	input, err := ioutil.ReadFile(src.Filename)
 	if err != nil {
@@ -143,7 +944,7 @@ func invokeGodef(cfg *packages.Config, src token.Position, runCount int) (*Objec
 	// repeat the actual godef part n times, for benchmark support
 	var obj *Object
 	for i := 0; i < runCount; i++ {
-		obj, err = adaptGodef(cfg, src.Filename, input, src.Offset)
+		obj, err = adaptGodef(cfg, src.Filename, input, src.Offset, "", flagOptions())
 		if err != nil {
 			return nil, fmt.Errorf("Failed %v: %v", src, err)
 		}
@@ -151,6 +952,18 @@ func invokeGodef(cfg *packages.Config, src token.Position, runCount int) (*Objec
 	return obj, nil
 }
 
+func invokePositions(f func(*packages.Config, string, []byte, int) ([]Position, error), cfg *packages.Config, src token.Position) ([]Position, error) {
+	input, err := ioutil.ReadFile(src.Filename)
+	if err != nil {
+		return nil, fmt.Errorf("Failed %v: %v", src, err)
+	}
+	positions, err := f(cfg, src.Filename, input, src.Offset)
+	if err != nil {
+		return nil, fmt.Errorf("Failed %v: %v", src, err)
+	}
+	return positions, nil
+}
+
 func localPos(pos token.Position, e *packagestest.Exported, modules []packagestest.Module) string {
 	fstat, fstatErr := os.Stat(pos.Filename)
 	if fstatErr != nil {
@@ -167,3 +980,14 @@ func localPos(pos token.Position, e *packagestest.Exported, modules []packageste
 	}
 	return pos.String()
 }
+
+func invokeCalls(f func(*packages.Config, string, []byte, int) ([]Call, error), cfg *packages.Config, src token.Position) ([]Position, error) {
+	return invokePositions(func(cfg *packages.Config, filename string, src []byte, searchpos int) ([]Position, error) {
+		calls, err := f(cfg, filename, src, searchpos)
+		var positions []Position
+		for _, call := range calls {
+			positions = append(positions, call.Position)
+		}
+		return positions, err
+	}, cfg, src)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"go/types"
+	"sort"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// godefImpl returns the locations related by interface satisfaction to
+// the object referred to by the identifier at searchpos. For an interface
+// type, these are the concrete named types that implement it, and for an
+// interface method, the corresponding concrete methods. For a concrete
+// type, they are the interfaces it implements, and for a concrete method,
+// the interface methods it implements.
+//
+// Candidate types are taken from the packages that depend on the
+// object's package, and from everything those packages import.
+func godefImpl(cfg *packages.Config, filename string, src []byte, searchpos int) ([]Position, error) {
+	lpkgs, targets, err := loadDependents(cfg, filename, src, searchpos)
+	if err != nil {
+		return nil, err
+	}
+	fset := lpkgs[0].Fset
+	named := namedTypes(lpkgs)
+	seen := make(map[Position]bool)
+	var result []Position
+	add := func(obj types.Object) {
+		if obj == nil || !obj.Pos().IsValid() {
+			return
+		}
+		pos := objToPos(fset, obj)
+		if !seen[pos] {
+			seen[pos] = true
+			result = append(result, pos)
+		}
+	}
+	for target := range targets {
+		switch target := target.(type) {
+		case *types.TypeName:
+			if iface, ok := target.Type().Underlying().(*types.Interface); ok {
+				for _, t := range named {
+					if !types.IsInterface(t) && implements(t, iface) {
+						add(t.Obj())
+					}
+				}
+				break
+			}
+			for _, t := range named {
+				if iface, ok := t.Underlying().(*types.Interface); ok && implements(target.Type(), iface) {
+					add(t.Obj())
+				}
+			}
+		case *types.Func:
+			recv := target.Type().(*types.Signature).Recv()
+			if recv == nil {
+				return nil, fmt.Errorf("%s is not a method", target.Name())
+			}
+			if iface, ok := recv.Type().Underlying().(*types.Interface); ok {
+				for _, t := range named {
+					if !types.IsInterface(t) && implements(t, iface) {
+						add(lookupMethod(t, target))
+					}
+				}
+				break
+			}
+			for _, t := range named {
+				if iface, ok := t.Underlying().(*types.Interface); ok && implements(recv.Type(), iface) {
+					add(lookupMethod(t, target))
+				}
+			}
+		default:
+			return nil, fmt.Errorf("%s is not a type or method", target.Name())
+		}
+	}
+	sort.Sort(orderedPositions(result))
+	return result, nil
+}
+
+// namedTypes returns every non-generic named type declared at package
+// level in lpkgs or in any package they import, directly or indirectly.
+func namedTypes(lpkgs []*packages.Package) []*types.Named {
+	var result []*types.Named
+	seen := make(map[*types.Package]bool)
+	var visit func(pkg *types.Package)
+	visit = func(pkg *types.Package) {
+		if pkg == nil || seen[pkg] {
+			return
+		}
+		seen[pkg] = true
+		scope := pkg.Scope()
+		for _, name := range scope.Names() {
+			tn, ok := scope.Lookup(name).(*types.TypeName)
+			if !ok || tn.IsAlias() {
+				continue
+			}
+			if t, ok := tn.Type().(*types.Named); ok && t.TypeParams().Len() == 0 {
+				result = append(result, t)
+			}
+		}
+		for _, imp := range pkg.Imports() {
+			visit(imp)
+		}
+	}
+	for _, lpkg := range lpkgs {
+		visit(lpkg.Types)
+	}
+	return result
+}
+
+// implements reports whether a value of type t, or of type *t when t is
+// not a pointer or interface, satisfies iface. Empty interfaces and
+// constraint-only interfaces are never considered to be implemented, as
+// listing them would be noise.
+func implements(t types.Type, iface *types.Interface) bool {
+	if iface.Empty() || !iface.IsMethodSet() {
+		return false
+	}
+	if types.Implements(t, iface) {
+		return true
+	}
+	if _, ok := t.Underlying().(*types.Pointer); ok || types.IsInterface(t) {
+		return false
+	}
+	return types.Implements(types.NewPointer(t), iface)
+}
+
+// lookupMethod returns the method of t (or *t) with the same name as m,
+// or nil if there is none.
+func lookupMethod(t types.Type, m *types.Func) types.Object {
+	obj, _, _ := types.LookupFieldOrMethod(t, true, m.Pkg(), m.Name())
+	if fn, ok := obj.(*types.Func); ok {
+		return fn
+	}
+	return nil
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// JSON-RPC error codes used by the language server.
+const (
+	lspParseError     = -32700
+	lspMethodNotFound = -32601
+	lspInvalidParams  = -32602
+	lspRequestFailed  = -32803
+)
+
+// lspMessage is a JSON-RPC request or notification sent by the client.
+type lspMessage struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// lspResponse is a JSON-RPC response sent to the client.
+type lspResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *lspError       `json:"error,omitempty"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *lspError) Error() string {
+	return e.Message
+}
+
+// lspPosition is a position in a document. Following the protocol's
+// default encoding, Character counts UTF-16 code units.
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspTextDocument struct {
+	URI  string `json:"uri"`
+	Text string `json:"text,omitempty"`
+}
+
+type lspPositionParams struct {
+	TextDocument lspTextDocument `json:"textDocument"`
+	Position     lspPosition     `json:"position"`
+	Context      struct {
+		IncludeDeclaration bool `json:"includeDeclaration"`
+	} `json:"context"`
+}
+
+type lspDidChangeParams struct {
+	TextDocument   lspTextDocument `json:"textDocument"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+type lspInitializeParams struct {
+	RootURI string `json:"rootUri"`
+}
+
+// lspServer answers Language Server Protocol requests.
+type lspServer struct {
+	ctx context.Context
+	out io.Writer
+	// dir is the workspace root given by the client.
+	dir string
+	// docs holds the contents of the documents open in the
+	// client, keyed by filename; they are used as the overlay
+	// when loading packages.
+	docs map[string][]byte
+}
+
+// serveLSP speaks the Language Server Protocol over in and out until
+// the client sends the exit notification or closes its input.
+func serveLSP(ctx context.Context, in io.Reader, out io.Writer) error {
+	s := &lspServer{
+		ctx:  ctx,
+		out:  out,
+		docs: make(map[string][]byte),
+	}
+	r := bufio.NewReader(in)
+	for {
+		body, err := readLSPMessage(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var msg lspMessage
+		if err := json.Unmarshal(body, &msg); err != nil {
+			if err := s.reply(json.RawMessage("null"), nil, &lspError{lspParseError, err.Error()}); err != nil {
+				return err
+			}
+			continue
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		result, err := s.handle(&msg)
+		if msg.ID == nil {
+			// Notifications have no response.
+			continue
+		}
+		if err := s.reply(msg.ID, result, err); err != nil {
+			return err
+		}
+	}
+}
+
+// readLSPMessage reads the body of the next message from r.
+func readLSPMessage(r *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && line == "" && length < 0 {
+				return nil, io.EOF
+			}
+			return nil, fmt.Errorf("cannot read message header: %v", err)
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if name, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(name, "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(value)); err != nil {
+				return nil, fmt.Errorf("invalid Content-Length %q", value)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("message has no Content-Length")
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("cannot read message body: %v", err)
+	}
+	return body, nil
+}
+
+// reply sends the response to the request with the given id.
+func (s *lspServer) reply(id json.RawMessage, result interface{}, err error) error {
+	resp := lspResponse{
+		JSONRPC: "2.0",
+		ID:      id,
+	}
+	if err != nil {
+		lerr, ok := err.(*lspError)
+		if !ok {
+			lerr = &lspError{lspRequestFailed, err.Error()}
+		}
+		resp.Error = lerr
+	} else {
+		data, err := json.Marshal(result)
+		if err != nil {
+			return fmt.Errorf("JSON marshal error: %v", err)
+		}
+		resp.Result = data
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return fmt.Errorf("JSON marshal error: %v", err)
+	}
+	_, err = fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(data), data)
+	return err
+}
+
+// handle handles a single request or notification
+// and returns the result to send back, if any.
+func (s *lspServer) handle(msg *lspMessage) (interface{}, error) {
+	switch msg.Method {
+	case "initialize":
+		var params lspInitializeParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &lspError{lspInvalidParams, err.Error()}
+		}
+		if params.RootURI != "" {
+			s.dir, _ = uriToFilename(params.RootURI)
+		}
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				// Documents are always sent in full.
+				"textDocumentSync":       1,
+				"definitionProvider":     true,
+				"typeDefinitionProvider": true,
+				"hoverProvider":          true,
+				"referencesProvider":     true,
+			},
+			"serverInfo": map[string]string{
+				"name": "godef",
+			},
+		}, nil
+
+	case "shutdown":
+		return nil, nil
+
+	case "textDocument/didOpen", "textDocument/didClose":
+		var params lspDidChangeParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		filename, err := uriToFilename(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		if msg.Method == "textDocument/didOpen" {
+			s.docs[filename] = []byte(params.TextDocument.Text)
+		} else {
+			delete(s.docs, filename)
+		}
+		return nil, nil
+
+	case "textDocument/didChange":
+		var params lspDidChangeParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		filename, err := uriToFilename(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		if n := len(params.ContentChanges); n > 0 {
+			s.docs[filename] = []byte(params.ContentChanges[n-1].Text)
+		}
+		return nil, nil
+
+	case "textDocument/definition", "textDocument/typeDefinition", "textDocument/hover", "textDocument/references":
+		var params lspPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &lspError{lspInvalidParams, err.Error()}
+		}
+		return s.query(msg.Method, &params)
+	}
+	if msg.ID == nil || strings.HasPrefix(msg.Method, "$/") {
+		return nil, nil
+	}
+	return nil, &lspError{lspMethodNotFound, fmt.Sprintf("method %q not supported", msg.Method)}
+}
+
+// query answers a request about the identifier at a position.
+func (s *lspServer) query(method string, params *lspPositionParams) (interface{}, error) {
+	filename, err := uriToFilename(params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	src, err := s.contents(filename)
+	if err != nil {
+		return nil, err
+	}
+	searchpos, err := lineColOffset(filename, src, params.Position.Line+1, params.Position.Character+1, utf16Units)
+	if err != nil {
+		return nil, &lspError{lspInvalidParams, err.Error()}
+	}
+	cfg := &packages.Config{
+		Context: s.ctx,
+		Dir:     s.dir,
+		Tests:   strings.HasSuffix(filename, "_test.go"),
+		Overlay: withOverlay(s.docs, filename, src),
+	}
+	configureBuild(cfg)
+
+	// The options are those of each method,
+	// whatever the command line flags.
+	switch method {
+	case "textDocument/definition", "textDocument/typeDefinition", "textDocument/hover":
+		opts := godefOptions{
+			typeInfo: method == "textDocument/hover",
+			typeDef:  method == "textDocument/typeDefinition",
+		}
+		obj, err := adaptGodef(cfg, filename, src, searchpos, "", opts)
+		if err != nil {
+			return nil, err
+		}
+		if method == "textDocument/hover" {
+			return map[string]interface{}{
+				"contents": map[string]string{
+					"kind":  "markdown",
+					"value": "```go\n" + typeStr(obj) + "\n```\n\n" + docComment(obj.Position, filename, src),
+				},
+			}, nil
+		}
+		if obj.Kind == PathKind {
+			return nil, nil
+		}
+		return s.locations([]Position{obj.Position}), nil
+
+	case "textDocument/references":
+		refs, err := godefRefs(cfg, filename, src, searchpos)
+		if err != nil {
+			return nil, err
+		}
+		if params.Context.IncludeDeclaration {
+			if obj, err := adaptGodef(cfg, filename, src, searchpos, "", godefOptions{}); err == nil {
+				refs = append([]Position{obj.Position}, refs...)
+			}
+		}
+		return s.locations(refs), nil
+	}
+	return nil, nil
+}
+
+// contents returns the contents of the named file,
+// preferring the client's copy if it has the file open.
+func (s *lspServer) contents(filename string) ([]byte, error) {
+	if src, ok := s.docs[filename]; ok {
+		return src, nil
+	}
+	return ioutil.ReadFile(filename)
+}
+
+// locations converts positions to LSP locations. Each location spans
+// the identifier starting at its position.
+func (s *lspServer) locations(positions []Position) []lspLocation {
+	result := []lspLocation{}
+	for _, pos := range positions {
+		if pos.Filename == "" || pos.Line < 1 {
+			continue
+		}
+		src, err := s.contents(pos.Filename)
+		if err != nil {
+			continue
+		}
+		start, end := identRange(src, pos.Line, pos.Column)
+		result = append(result, lspLocation{
+			URI: filenameToURI(pos.Filename),
+			Range: lspRange{
+				Start: lspPos(src, start),
+				End:   lspPos(src, end),
+			},
+		})
+	}
+	return result
+}
+
+// lspPos returns the LSP position of the byte offset in src.
+func lspPos(src []byte, offset int) lspPosition {
+	if offset > len(src) {
+		offset = len(src)
+	}
+	line := bytes.Count(src[:offset], []byte("\n"))
+	start := bytes.LastIndexByte(src[:offset], '\n') + 1
+	return lspPosition{
+		Line:      line,
+		Character: len(utf16.Encode([]rune(string(src[start:offset])))),
+	}
+}
+
+// identRange returns the byte offsets in src of the start and end of the
+// identifier at the given line and byte column, both counted from 1.
+func identRange(src []byte, line, col int) (start, end int) {
+	for l := 1; l < line; l++ {
+		i := bytes.IndexByte(src[start:], '\n')
+		if i < 0 {
+			return len(src), len(src)
+		}
+		start += i + 1
+	}
+	start += col - 1
+	if start > len(src) {
+		start = len(src)
+	}
+	end = start
+	for end < len(src) {
+		r, size := utf8.DecodeRune(src[end:])
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			break
+		}
+		end += size
+	}
+	return start, end
+}
+
+func uriToFilename(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "file" {
+		return "", fmt.Errorf("unsupported URI %q", uri)
+	}
+	path := u.Path
+	// Windows paths look like /C:/dir/file.go
+	if len(path) >= 3 && path[0] == '/' && path[2] == ':' {
+		path = path[1:]
+	}
+	return filepath.FromSlash(path), nil
+}
+
+func filenameToURI(filename string) string {
+	path := filepath.ToSlash(filename)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	u := url.URL{Scheme: "file", Path: path}
+	return u.String()
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// readOverlay reads an archive of modified files, as given by -modified.
+// Each file in the archive is its name and its size in bytes, each on a
+// line of its own, followed by its contents. Relative names are
+// interpreted relative to dir, or to the current directory if dir is
+// empty. The result maps absolute filenames to their contents.
+func readOverlay(r io.Reader, dir string) (map[string][]byte, error) {
+	overlay := make(map[string][]byte)
+	in := bufio.NewReader(r)
+	for {
+		name, err := in.ReadString('\n')
+		if err == io.EOF && name == "" {
+			return overlay, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading modified file name: %v", err)
+		}
+		name = strings.TrimSuffix(name, "\n")
+		sizeStr, err := in.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("reading size of modified file %s: %v", name, err)
+		}
+		size, err := strconv.Atoi(strings.TrimSuffix(sizeStr, "\n"))
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid size of modified file %s: %q", name, sizeStr)
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(in, data); err != nil {
+			return nil, fmt.Errorf("reading modified file %s: %v", name, err)
+		}
+		overlay[absPath(dir, name)] = data
+	}
+}
+
+// absPath returns filename as an absolute path,
+// interpreting a relative one relative to dir.
+func absPath(dir, filename string) string {
+	if filepath.IsAbs(filename) {
+		return filepath.Clean(filename)
+	}
+	if dir != "" {
+		return filepath.Join(dir, filename)
+	}
+	if abs, err := filepath.Abs(filename); err == nil {
+		return abs
+	}
+	return filename
+}
@@ -1,28 +1,49 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"go/ast"
 	"go/parser"
+	"go/scanner"
 	"go/token"
 	"go/types"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 
 	"golang.org/x/tools/go/ast/astutil"
 	"golang.org/x/tools/go/packages"
 )
 
-func godefPackages(cfg *packages.Config, filename string, src []byte, searchpos int) (*token.FileSet, types.Object, error) {
+func godefPackages(cfg *packages.Config, filename string, src []byte, searchpos int, expr string) (*token.FileSet, types.Object, error) {
+	var lpkg *packages.Package
+	var obj types.Object
+	var err error
+	if expr != "" {
+		lpkg, obj, err = evalObject(cfg, filename, src, expr)
+	} else {
+		lpkg, obj, err = loadObject(cfg, filename, src, searchpos)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return lpkg.Fset, obj, nil
+}
+
+// loadObject loads the package containing filename and returns it
+// along with the object referred to by the identifier at searchpos.
+func loadObject(cfg *packages.Config, filename string, src []byte, searchpos int) (*packages.Package, types.Object, error) {
+	if pkgCache != nil {
+		return pkgCache.loadObject(cfg, filename, src, searchpos)
+	}
 	parser, result := parseFile(filename, searchpos)
 	// Load, parse, and type-check the packages named on the command line.
 	if src != nil {
-		cfg.Overlay = map[string][]byte{
-			filename: src,
-		}
+		cfg.Overlay = withOverlay(cfg.Overlay, filename, src)
 	}
-	cfg.Mode = packages.LoadSyntax
+	cfg.Mode = packages.LoadSyntax | packages.NeedModule
 	cfg.ParseFile = parser
 	lpkgs, err := packages.Load(cfg, "file="+filename)
 	if err != nil {
@@ -41,18 +62,105 @@ func godefPackages(cfg *packages.Config, filename string, src []byte, searchpos
 	if m.ident == nil {
 		return nil, nil, fmt.Errorf("Offset %d was not a valid identifier", searchpos)
 	}
-	obj := lpkgs[0].TypesInfo.ObjectOf(m.ident)
+	obj := matchObject(lpkgs[0], m)
+	if obj == nil {
+		return nil, nil, fmt.Errorf("no object")
+	}
+	return lpkgs[0], obj, nil
+}
+
+// evalObject loads the package containing filename and returns it
+// along with the object referred to by expr, evaluated in the scope
+// of the file. The expression must be an identifier or end in a
+// selector, as in a.B, x.y.z or f().g[0].h.
+func evalObject(cfg *packages.Config, filename string, src []byte, expr string) (*packages.Package, types.Object, error) {
+	e, err := parser.ParseExpr(expr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot parse expression: %v", err)
+	}
+	if src != nil {
+		cfg.Overlay = withOverlay(cfg.Overlay, filename, src)
+	}
+	cfg.Mode = packages.LoadSyntax | packages.NeedModule
+	var lpkgs []*packages.Package
+	parse := parseGoFile(filename)
+	if pkgCache != nil {
+		cfg.ParseFile = parse
+		lpkgs, err = pkgCache.load(cfg, filename)
+	} else {
+		// Only declarations are needed to evaluate the expression.
+		cfg.ParseFile = func(fset *token.FileSet, fname string, filedata []byte) (*ast.File, error) {
+			file, err := parse(fset, fname, filedata)
+			if file != nil {
+				trimAST(file, token.NoPos)
+			}
+			return file, err
+		}
+		lpkgs, err = packages.Load(cfg, "file="+filename)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	isInputFile := newFileCompare(filename)
+	for _, lpkg := range lpkgs {
+		for _, file := range lpkg.Syntax {
+			if !isInputFile(lpkg.Fset.Position(file.Pos()).Filename) {
+				continue
+			}
+			obj, err := exprObject(lpkg, file, e)
+			if err != nil {
+				return nil, nil, err
+			}
+			return lpkg, obj, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("There must be at least one package that contains the file")
+}
+
+// exprObject type-checks e in the scope of file, which belongs to the
+// package lpkg, and returns the object that e refers to.
+func exprObject(lpkg *packages.Package, file *ast.File, e ast.Expr) (types.Object, error) {
+	e = ast.Unparen(e)
+	if id, ok := e.(*ast.Ident); ok {
+		// A package name on its own is not a valid expression,
+		// so look identifiers up directly.
+		if scope := lpkg.TypesInfo.Scopes[file]; scope != nil {
+			if _, obj := scope.LookupParent(id.Name, token.NoPos); obj != nil {
+				return obj, nil
+			}
+		}
+		return nil, fmt.Errorf("undefined: %s", id.Name)
+	}
+	sel, ok := e.(*ast.SelectorExpr)
+	if !ok {
+		return nil, fmt.Errorf("expression must be an identifier or a selector")
+	}
+	info := &types.Info{
+		Uses:       make(map[*ast.Ident]types.Object),
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
+	}
+	if err := types.CheckExpr(lpkg.Fset, lpkg.Types, file.Package, sel, info); err != nil {
+		return nil, err
+	}
+	obj := info.Uses[sel.Sel]
+	if obj == nil {
+		return nil, fmt.Errorf("no object for %s", sel.Sel.Name)
+	}
+	return obj, nil
+}
+
+// matchObject returns the object that the matched identifier refers to
+// within the type-checked package lpkg, or nil if there is none.
+func matchObject(lpkg *packages.Package, m match) types.Object {
+	obj := lpkg.TypesInfo.ObjectOf(m.ident)
 	if obj == nil && !m.ident.Pos().IsValid() {
-		pkg := lpkgs[0].Imports[m.ident.Name]
+		pkg := lpkg.Imports[m.ident.Name]
 		if pkg != nil && len(pkg.GoFiles) > 0 {
 			dir := filepath.Dir(pkg.GoFiles[0])
 			obj = types.NewPkgName(token.NoPos, nil, "", types.NewPackage(dir, ""))
 		}
 	}
-	if obj == nil {
-		return nil, nil, fmt.Errorf("no object")
-	}
-	if m.wasEmbeddedField {
+	if obj != nil && m.wasEmbeddedField {
 		// the original position was on the embedded field declaration
 		// so we try to dig out the type and jump to that instead
 		if v, ok := obj.(*types.Var); ok {
@@ -61,7 +169,58 @@ func godefPackages(cfg *packages.Config, filename string, src []byte, searchpos
 			}
 		}
 	}
-	return lpkgs[0].Fset, obj, nil
+	return obj
+}
+
+// namedTypeOf returns the declaration of the named type t, or of the
+// named type reached from t by following pointers, slices, arrays, maps
+// and channels to their elements and functions to their first result.
+// The predeclared types, including any, count as named. It returns nil
+// if there is none.
+func namedTypeOf(t types.Type) *types.TypeName {
+	for {
+		if a, ok := t.(*types.Alias); ok && a.Obj().Parent() == types.Universe {
+			// Unaliasing any would lose its name.
+			return a.Obj()
+		}
+		switch u := types.Unalias(t).(type) {
+		case *types.Named:
+			return u.Obj()
+		case *types.TypeParam:
+			return u.Obj()
+		case *types.Pointer:
+			t = u.Elem()
+		case *types.Slice:
+			t = u.Elem()
+		case *types.Array:
+			t = u.Elem()
+		case *types.Map:
+			t = u.Elem()
+		case *types.Chan:
+			t = u.Elem()
+		case *types.Signature:
+			if u.Results().Len() == 0 {
+				return nil
+			}
+			t = u.Results().At(0).Type()
+		case *types.Basic:
+			tobj, _ := types.Universe.Lookup(u.Name()).(*types.TypeName)
+			return tobj
+		default:
+			return nil
+		}
+	}
+}
+
+// withOverlay returns a copy of overlay in which
+// the contents of filename are replaced by src.
+func withOverlay(overlay map[string][]byte, filename string, src []byte) map[string][]byte {
+	result := make(map[string][]byte, len(overlay)+1)
+	for name, data := range overlay {
+		result[name] = data
+	}
+	result[filename] = src
+	return result
 }
 
 // match holds the ident plus any extra information needed
@@ -80,10 +239,13 @@ func parseFile(filename string, searchpos int) (func(*token.FileSet, string, []b
 	isInputFile := newFileCompare(filename)
 	return func(fset *token.FileSet, fname string, filedata []byte) (*ast.File, error) {
 		isInput := isInputFile(fname)
-		file, err := parser.ParseFile(fset, fname, filedata, 0)
+		file, err := parser.ParseFile(fset, fname, filedata, parser.AllErrors)
 		if file == nil {
 			return nil, err
 		}
+		if err != nil && isInput {
+			file = parseBroken(fset, file, filedata, err)
+		}
 		pos := token.Pos(-1)
 		if isInput {
 			tfile := fset.File(file.Pos())
@@ -106,6 +268,193 @@ func parseFile(filename string, searchpos int) (func(*token.FileSet, string, []b
 	}, result
 }
 
+// parseGoFile returns a function that parses files for packages.Config
+// with AllErrors, so that parsing goes on past the first few syntax
+// errors. Only filename, the file being edited, is parsed with
+// parseBroken if it has syntax errors; other files are left as go/parser
+// returns them.
+func parseGoFile(filename string) func(*token.FileSet, string, []byte) (*ast.File, error) {
+	isInputFile := newFileCompare(filename)
+	return func(fset *token.FileSet, fname string, filedata []byte) (*ast.File, error) {
+		file, err := parser.ParseFile(fset, fname, filedata, parser.AllErrors)
+		if err == nil || file == nil || !isInputFile(fname) {
+			return file, err
+		}
+		return parseBroken(fset, file, filedata, err), err
+	}
+}
+
+// parseBroken returns file, parsed from src with the syntax errors err,
+// with the declarations spoilt by the errors parsed again. go/parser
+// returns what it can of a file with syntax errors, but it gives up on
+// the rest of a block it cannot close, taking the declarations that
+// follow for statements, and skips ahead to the next statement keyword
+// after a bad statement. So each part of src between two keywords at
+// the start of a line that holds an error, or that is taken up by a
+// declaration holding one, is parsed again on its own: from a copy of
+// src in which the rest is blanked out, along with the rest of each
+// bad line. The copy has the same offsets as src, and is parsed into
+// a FileSet that gives it the positions of file.
+func parseBroken(fset *token.FileSet, file *ast.File, src []byte, err error) *ast.File {
+	list, ok := err.(scanner.ErrorList)
+	if !ok {
+		return file
+	}
+	tfile := fset.File(file.Pos())
+	offset := func(pos token.Pos) int {
+		return min(max(int(pos)-tfile.Base(), 0), len(src))
+	}
+	starts := declStarts(src)
+	part := func(offset int) int {
+		return sort.SearchInts(starts, offset+1) - 1
+	}
+	bad := make(map[int]bool)
+	for _, e := range list {
+		bad[max(part(e.Pos.Offset), 0)] = true
+	}
+	// A declaration that takes up a part holding an error
+	// spoils all the parts it takes up.
+	spoilt := make(map[ast.Decl]bool)
+	for changed := true; changed; {
+		changed = false
+		for _, d := range file.Decls {
+			if spoilt[d] {
+				continue
+			}
+			first, last := max(part(offset(d.Pos())), 0), part(offset(d.End())-1)
+			for i := first; i <= last; i++ {
+				spoilt[d] = spoilt[d] || bad[i]
+			}
+			if spoilt[d] {
+				for i := first; i <= last; i++ {
+					bad[i] = true
+				}
+				changed = true
+			}
+		}
+	}
+	var decls []ast.Decl
+	for _, d := range file.Decls {
+		if !spoilt[d] {
+			decls = append(decls, d)
+		}
+	}
+	for i, start := range starts {
+		if !bad[i] {
+			continue
+		}
+		end := len(src)
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		buf := bytes.Clone(src)
+		blank(buf[starts[0]:start])
+		blank(buf[end:])
+		blankErrors(buf)
+		// The package clause and imports are kept, but
+		// only the declarations of the part are wanted.
+		if f, _ := parser.ParseFile(alignedFileSet(tfile.Base()), tfile.Name(), buf, parser.AllErrors); f != nil {
+			for _, d := range f.Decls {
+				if offset(d.Pos()) >= start {
+					decls = append(decls, d)
+				}
+			}
+		}
+	}
+	sort.Slice(decls, func(i, j int) bool { return decls[i].Pos() < decls[j].Pos() })
+	file.Decls = decls
+	return file
+}
+
+// declStarts returns the offsets in src of the keywords that start
+// top-level declarations at the beginning of a line.
+func declStarts(src []byte) []int {
+	var s scanner.Scanner
+	tfile := token.NewFileSet().AddFile("", -1, len(src))
+	s.Init(tfile, src, nil, 0)
+	var starts []int
+	for {
+		pos, tok, _ := s.Scan()
+		switch tok {
+		case token.EOF:
+			return starts
+		case token.IMPORT, token.CONST, token.TYPE, token.VAR, token.FUNC:
+			if offset := tfile.Offset(pos); offset == 0 || src[offset-1] == '\n' {
+				starts = append(starts, offset)
+			}
+		}
+	}
+}
+
+// alignedFileSet returns a new FileSet in which the
+// next file added has the given base.
+func alignedFileSet(base int) *token.FileSet {
+	fset := token.NewFileSet()
+	if base > fset.Base() {
+		fset.AddFile("", fset.Base(), base-fset.Base()-1)
+	}
+	return fset
+}
+
+// blank replaces everything but the newlines in src with spaces.
+func blank(src []byte) {
+	for i, c := range src {
+		if c != '\n' {
+			src[i] = ' '
+		}
+	}
+}
+
+// maxBlankedLines limits the number of bad lines blanked out
+// by blankErrors.
+const maxBlankedLines = 50
+
+// blankErrors replaces with spaces the rest of the line of src at which
+// go/parser reports the first syntax error, from the end of the last
+// token that could end a statement, until src parses or there is
+// nothing left on the line to blank out. The offsets in src are
+// unchanged.
+func blankErrors(src []byte) {
+	for i := 0; i < maxBlankedLines; i++ {
+		_, err := parser.ParseFile(token.NewFileSet(), "", src, parser.AllErrors)
+		list, ok := err.(scanner.ErrorList)
+		if !ok || len(list) == 0 {
+			return
+		}
+		offset := list[0].Pos.Offset
+		start := bytes.LastIndexByte(src[:offset], '\n') + 1
+		end := len(src)
+		if n := bytes.IndexByte(src[offset:], '\n'); n >= 0 {
+			end = offset + n
+		}
+		var s scanner.Scanner
+		tfile := token.NewFileSet().AddFile("", -1, offset-start)
+		s.Init(tfile, src[start:offset], nil, 0)
+		from := start
+	scan:
+		for {
+			pos, tok, lit := s.Scan()
+			switch tok {
+			case token.EOF:
+				break scan
+			case token.IDENT, token.INT, token.FLOAT, token.IMAG, token.CHAR, token.STRING,
+				token.RPAREN, token.RBRACK, token.RBRACE, token.INC, token.DEC,
+				token.BREAK, token.CONTINUE, token.FALLTHROUGH, token.RETURN:
+				// A semicolon would be inserted after these
+				// at the end of the line.
+				if lit == "" {
+					lit = tok.String()
+				}
+				from = start + tfile.Offset(pos) + len(lit)
+			}
+		}
+		if len(bytes.TrimSpace(src[from:end])) == 0 {
+			return
+		}
+		blank(src[from:end])
+	}
+}
+
 // newFileCompare returns a function that reports whether its argument
 // refers to the same file as the given filename.
 func newFileCompare(filename string) func(string) bool {
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"go/token"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Units in which the column of a -pos position can be counted.
+const (
+	byteUnits  = "bytes"
+	runeUnits  = "runes"
+	utf16Units = "utf16"
+)
+
+// parsePos splits a position of the form file:line:column.
+func parsePos(pos string) (filename string, line, col int, err error) {
+	i := strings.LastIndex(pos, ":")
+	j := -1
+	if i > 0 {
+		j = strings.LastIndex(pos[:i], ":")
+	}
+	if j <= 0 {
+		return "", 0, 0, fmt.Errorf("invalid position %q, want file:line:column", pos)
+	}
+	if line, err = strconv.Atoi(pos[j+1 : i]); err != nil {
+		return "", 0, 0, fmt.Errorf("invalid line in position %q", pos)
+	}
+	if col, err = strconv.Atoi(pos[i+1:]); err != nil {
+		return "", 0, 0, fmt.Errorf("invalid column in position %q", pos)
+	}
+	return pos[:j], line, col, nil
+}
+
+// lineColOffset returns the byte offset in src of the given line and
+// column, both counted from 1, with the column counted in units.
+// A column just past the end of the line is allowed, and so is the
+// first column of the empty line after a final newline, which is
+// the end of the file.
+func lineColOffset(filename string, src []byte, line, col int, units string) (int, error) {
+	switch units {
+	case byteUnits, runeUnits, utf16Units:
+	default:
+		return 0, fmt.Errorf("unknown column units %q", units)
+	}
+	tfile := token.NewFileSet().AddFile(filename, -1, len(src))
+	tfile.SetLinesForContent(src)
+	if line == tfile.LineCount()+1 && col == 1 && (len(src) == 0 || src[len(src)-1] == '\n') {
+		return len(src), nil
+	}
+	if line < 1 || line > tfile.LineCount() {
+		return 0, fmt.Errorf("line %d is not in %s (%d lines)", line, filename, tfile.LineCount())
+	}
+	if col < 1 {
+		return 0, fmt.Errorf("invalid column %d", col)
+	}
+	start := tfile.Offset(tfile.LineStart(line))
+	end := len(src)
+	if line < tfile.LineCount() {
+		end = tfile.Offset(tfile.LineStart(line+1)) - 1
+	}
+	if units == byteUnits {
+		if start+col-1 > end {
+			return 0, fmt.Errorf("column %d is beyond end of line %d of %s", col, line, filename)
+		}
+		return start + col - 1, nil
+	}
+	offset := start
+	for n := 1; n < col; {
+		if offset >= end {
+			return 0, fmt.Errorf("column %d is beyond end of line %d of %s", col, line, filename)
+		}
+		r, size := utf8.DecodeRune(src[offset:end])
+		if units == utf16Units {
+			n += len(utf16.Encode([]rune{r}))
+		} else {
+			n++
+		}
+		offset += size
+	}
+	return offset, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// predeclaredFile holds the declarations of the source of package
+// builtin or unsafe. Pos maps each name to the position of its
+// declaration, and funcs each function name to its declaration.
+type predeclaredFile struct {
+	fset  *token.FileSet
+	pos   map[string]Position
+	funcs map[string]*ast.FuncDecl
+}
+
+var predeclared = struct {
+	mu    sync.Mutex
+	files map[string]*predeclaredFile
+}{files: make(map[string]*predeclaredFile)}
+
+// predeclaredPos returns the position of the declaration of name in the
+// source of package pkg, either builtin or unsafe, in $GOROOT. These
+// packages document the predeclared objects and those of package unsafe,
+// which have no source of their own. The methods of predeclared types
+// are named type.method, as in error.Error. The zero Position is
+// returned if there is no such declaration.
+func predeclaredPos(pkg, name string) Position {
+	return predeclaredDecls(pkg).pos[name]
+}
+
+// predeclaredDecls returns the declarations of package pkg,
+// either builtin or unsafe, parsing its source the first time.
+func predeclaredDecls(pkg string) *predeclaredFile {
+	predeclared.mu.Lock()
+	defer predeclared.mu.Unlock()
+	file, ok := predeclared.files[pkg]
+	if !ok {
+		goroot := build.Default.GOROOT
+		if goroot == "" {
+			goroot = runtime.GOROOT()
+		}
+		file = parsePredeclared(filepath.Join(goroot, "src", pkg, pkg+".go"))
+		predeclared.files[pkg] = file
+	}
+	return file
+}
+
+// parsePredeclared returns the top-level declarations in filename,
+// and the methods of its interfaces.
+func parsePredeclared(filename string) *predeclaredFile {
+	file := &predeclaredFile{
+		fset:  token.NewFileSet(),
+		pos:   make(map[string]Position),
+		funcs: make(map[string]*ast.FuncDecl),
+	}
+	f, err := parser.ParseFile(file.fset, filename, nil, 0)
+	if err != nil {
+		return file
+	}
+	add := func(prefix string, id *ast.Ident) {
+		pos := file.fset.Position(id.Pos())
+		file.pos[prefix+id.Name] = Position{
+			Filename: pos.Filename,
+			Line:     pos.Line,
+			Column:   pos.Column,
+		}
+	}
+	for _, decl := range f.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			add("", decl.Name)
+			file.funcs[decl.Name.Name] = decl
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					add("", spec.Name)
+					if it, ok := spec.Type.(*ast.InterfaceType); ok {
+						for _, m := range it.Methods.List {
+							for _, id := range m.Names {
+								add(spec.Name.Name+".", id)
+							}
+						}
+					}
+				case *ast.ValueSpec:
+					for _, id := range spec.Names {
+						add("", id)
+					}
+				}
+			}
+		}
+	}
+	return file
+}
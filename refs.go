@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// godefRefs returns the position of every use of the object referred to
+// by the identifier at searchpos. All the packages of the enclosing module
+// (or, outside module mode, those below the file's directory) that depend
+// on the object's package are searched, including their tests.
+func godefRefs(cfg *packages.Config, filename string, src []byte, searchpos int) ([]Position, error) {
+	lpkgs, targets, err := loadDependents(cfg, filename, src, searchpos)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[Position]bool)
+	var refs []Position
+	for _, lpkg := range lpkgs {
+		for id, obj := range lpkg.TypesInfo.Uses {
+			if !targets[originObject(obj)] {
+				continue
+			}
+			pos := identPos(lpkg.Fset, id)
+			if !seen[pos] {
+				seen[pos] = true
+				refs = append(refs, pos)
+			}
+		}
+	}
+	sort.Sort(orderedPositions(refs))
+	return refs, nil
+}
+
+// loadDependents resolves the identifier at searchpos, then loads with
+// full syntax every package of the enclosing module that depends on the
+// package declaring the object. It returns the loaded packages and the
+// object as resolved in each package containing filename (there may be
+// several when test variants are loaded).
+func loadDependents(cfg *packages.Config, filename string, src []byte, searchpos int) ([]*packages.Package, map[types.Object]bool, error) {
+	base := *cfg
+	lpkg, obj, err := loadObject(cfg, filename, src, searchpos)
+	if err != nil {
+		return nil, nil, err
+	}
+	if obj.Pkg() == nil {
+		return nil, nil, fmt.Errorf("cannot search dependents of %s", obj.Name())
+	}
+	root := filepath.Dir(filename)
+	if lpkg.Module != nil && lpkg.Module.Dir != "" {
+		root = lpkg.Module.Dir
+	}
+	patterns, err := reverseDeps(&base, root, obj.Pkg().Path())
+	if err != nil {
+		return nil, nil, err
+	}
+	lpkgs, err := loadSyntax(&base, src, filename, append(patterns, "file="+filename)...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Resolve the object again in each of the freshly loaded packages
+	// that contain the file, so that it can be compared by identity.
+	targets := make(map[types.Object]bool)
+	isInputFile := newFileCompare(filename)
+	for _, lpkg := range lpkgs {
+		if obj, _ := findObject(lpkg, isInputFile, searchpos); obj != nil {
+			targets[originObject(obj)] = true
+		}
+	}
+	if len(targets) == 0 {
+		return nil, nil, fmt.Errorf("no object")
+	}
+	return lpkgs, targets, nil
+}
+
+// findObject returns the object referred to by the identifier at
+// searchpos in the file of lpkg for which isInputFile returns true.
+// It returns a nil object and error if lpkg does not contain the file.
+func findObject(lpkg *packages.Package, isInputFile func(string) bool, searchpos int) (types.Object, error) {
+	for _, f := range lpkg.Syntax {
+		tfile := lpkg.Fset.File(f.Pos())
+		if tfile == nil || !isInputFile(tfile.Name()) {
+			continue
+		}
+		if searchpos > tfile.Size() {
+			return nil, fmt.Errorf("cursor %d is beyond end of file %s (%d)", searchpos, tfile.Name(), tfile.Size())
+		}
+		m, err := findMatch(f, tfile.Pos(searchpos))
+		if err != nil {
+			return nil, err
+		}
+		if m.ident == nil {
+			return nil, fmt.Errorf("Offset %d was not a valid identifier", searchpos)
+		}
+		if obj := matchObject(lpkg, m); obj != nil {
+			return obj, nil
+		}
+		return nil, fmt.Errorf("no object")
+	}
+	return nil, nil
+}
+
+// loadSyntax loads the packages matching patterns with complete syntax
+// trees and type information, with src (if non-nil) overriding the
+// contents of filename.
+func loadSyntax(cfg *packages.Config, src []byte, filename string, patterns ...string) ([]*packages.Package, error) {
+	c := *cfg
+	if src != nil {
+		c.Overlay = withOverlay(c.Overlay, filename, src)
+	}
+	c.Mode = packages.LoadSyntax | packages.NeedModule
+	c.ParseFile = nil
+	c.Tests = true
+	return packages.Load(&c, patterns...)
+}
+
+// reverseDeps returns patterns that load every package below root that
+// is, or transitively imports, the package with the given path.
+func reverseDeps(cfg *packages.Config, root, path string) ([]string, error) {
+	c := *cfg
+	c.Dir = root
+	c.Mode = packages.NeedName | packages.NeedImports
+	c.Tests = true
+	lpkgs, err := packages.Load(&c, "./...")
+	if err != nil {
+		return nil, err
+	}
+	importers := make(map[string][]*packages.Package)
+	var queue []*packages.Package
+	for _, lpkg := range lpkgs {
+		if lpkg.PkgPath == path {
+			queue = append(queue, lpkg)
+		}
+		for _, imp := range lpkg.Imports {
+			importers[imp.ID] = append(importers[imp.ID], lpkg)
+		}
+	}
+	found := make(map[string]bool)
+	for _, lpkg := range importers[path] {
+		queue = append(queue, lpkg)
+	}
+	var patterns []string
+	for len(queue) > 0 {
+		lpkg := queue[0]
+		queue = queue[1:]
+		if found[lpkg.ID] {
+			continue
+		}
+		found[lpkg.ID] = true
+		if p := loadPattern(lpkg); p != "" {
+			patterns = append(patterns, p)
+		}
+		queue = append(queue, importers[lpkg.ID]...)
+	}
+	sort.Strings(patterns)
+	return dedupStrings(patterns), nil
+}
+
+// loadPattern returns the pattern that loads lpkg when tests are
+// included, or the empty string for generated test mains.
+func loadPattern(lpkg *packages.Package) string {
+	if i := strings.Index(lpkg.ID, " ["); i >= 0 {
+		return strings.TrimSuffix(strings.TrimSuffix(lpkg.ID[i+2:], "]"), ".test")
+	}
+	if lpkg.Name == "main" && strings.HasSuffix(lpkg.PkgPath, ".test") {
+		return ""
+	}
+	return lpkg.PkgPath
+}
+
+// dedupStrings removes adjacent duplicates from the sorted slice s.
+func dedupStrings(s []string) []string {
+	var result []string
+	for i, x := range s {
+		if i == 0 || x != s[i-1] {
+			result = append(result, x)
+		}
+	}
+	return result
+}
+
+// originObject maps fields and methods of instantiated generic types
+// back to their generic declaration.
+func originObject(obj types.Object) types.Object {
+	switch obj := obj.(type) {
+	case *types.Func:
+		return obj.Origin()
+	case *types.Var:
+		return obj.Origin()
+	}
+	return obj
+}
+
+func identPos(fset *token.FileSet, id *ast.Ident) Position {
+	p := fset.Position(id.Pos())
+	return Position{
+		Filename: cleanFilename(p.Filename),
+		Line:     p.Line,
+		Column:   p.Column,
+	}
+}
+
+type orderedPositions []Position
+
+func (o orderedPositions) Len() int      { return len(o) }
+func (o orderedPositions) Swap(i, j int) { o[i], o[j] = o[j], o[i] }
+func (o orderedPositions) Less(i, j int) bool {
+	switch {
+	case o[i].Filename != o[j].Filename:
+		return o[i].Filename < o[j].Filename
+	case o[i].Line != o[j].Line:
+		return o[i].Line < o[j].Line
+	}
+	return o[i].Column < o[j].Column
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	debugpkg "runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/bobg/godef/go/types"
+)
+
+// serverRequest is sent by a client to the godef server.
+// It holds everything needed to answer the query as if
+// godef had been invoked directly by the client.
+type serverRequest struct {
+	Args  []string `json:"args"`
+	Dir   string   `json:"dir"`
+	Env   []string `json:"env"`
+	Stdin []byte   `json:"stdin,omitempty"`
+}
+
+// serverResponse is sent back by the server in reply to a serverRequest.
+type serverResponse struct {
+	Stdout []byte `json:"stdout,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// defaultSocket returns the default socket name for the godef server,
+// in a directory of its own under $XDG_RUNTIME_DIR or, if that is not
+// set, the user's cache directory. It is empty if neither is known.
+func defaultSocket() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		var err error
+		if dir, err = os.UserCacheDir(); err != nil {
+			return ""
+		}
+	}
+	return filepath.Join(dir, "godef", "godef.sock")
+}
+
+// isGoVar reports whether the environment variable e configures
+// the go command or the build, as GOFLAGS and CGO_ENABLED do.
+func isGoVar(e string) bool {
+	name, _, _ := strings.Cut(e, "=")
+	return (strings.HasPrefix(name, "GO") && !strings.Contains(name, "_")) || strings.HasPrefix(name, "CGO_")
+}
+
+// goEnv returns the variables of env that configure the go command or
+// the build. Only these are forwarded to the server.
+func goEnv(env []string) []string {
+	var result []string
+	for _, e := range env {
+		if isGoVar(e) {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// serve listens on the Unix socket and answers queries until ctx is
+// done or the process is interrupted. Loaded packages are kept between
+// queries and reused for as long as their files are unchanged.
+func serve(ctx context.Context, socket string) error {
+	// Requests carry the user's environment and files, so the socket
+	// is in a directory that no other user can get into.
+	dir := filepath.Dir(socket)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if err := checkPrivate(dir); err != nil {
+		return err
+	}
+	if conn, err := net.Dial("unix", socket); err == nil {
+		conn.Close()
+		return fmt.Errorf("a server is already listening on %s", socket)
+	}
+	// Any socket file left behind is stale.
+	os.Remove(socket)
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	// A long-running server should collect garbage as usual.
+	debugpkg.SetGCPercent(100)
+	pkgCache = newPackageCache()
+	types.CacheImports(true)
+	defer types.CacheImports(false)
+	// Bad flags in a request must not stop the server.
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	flag.CommandLine.SetOutput(io.Discard)
+
+	// Queries are answered one at a time, because
+	// they are described by the global flag values.
+	var mu sync.Mutex
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			var req serverRequest
+			if err := json.NewDecoder(conn).Decode(&req); err != nil {
+				log.Printf("bad request: %v", err)
+				return
+			}
+			mu.Lock()
+			resp := answer(ctx, &req)
+			mu.Unlock()
+			if err := json.NewEncoder(conn).Encode(resp); err != nil {
+				log.Printf("cannot send response: %v", err)
+			}
+		}()
+	}
+}
+
+// answer runs the query described by req.
+func answer(ctx context.Context, req *serverRequest) *serverResponse {
+	resetFlags()
+	if err := flag.CommandLine.Parse(req.Args); err != nil {
+		return &serverResponse{Error: err.Error()}
+	}
+	switch {
+	case flag.NArg() > 1:
+		return &serverResponse{Error: "too many arguments"}
+	case *serveFlag || *lspFlag || *batchFlag || *acmeFlag:
+		return &serverResponse{Error: "-serve, -lsp, -batch and -acme cannot be forwarded to a server"}
+	}
+	// The variables that configure the go command
+	// are the client's, and the others the server's.
+	env := append(slices.DeleteFunc(os.Environ(), isGoVar), goEnv(req.Env)...)
+	out := &bytes.Buffer{}
+	if err := query(ctx, req.Dir, env, bytes.NewReader(req.Stdin), out); err != nil {
+		return &serverResponse{Stdout: out.Bytes(), Error: err.Error()}
+	}
+	return &serverResponse{Stdout: out.Bytes()}
+}
+
+// resetFlags restores every godef flag to its default value,
+// leaving alone the flags registered by the testing package.
+func resetFlags() {
+	flag.VisitAll(func(f *flag.Flag) {
+		if !strings.HasPrefix(f.Name, "test.") {
+			f.Value.Set(f.DefValue)
+		}
+	})
+}
+
+// forward sends req to the godef server listening on socket, if there is
+// one, and copies the output of the query to out. It reports whether a
+// server answered the query. The server must be the user's own, listening
+// on a socket in a directory that no other user can get into.
+func forward(socket string, req *serverRequest, out io.Writer) (bool, error) {
+	if socket == "" {
+		return false, nil
+	}
+	if checkPrivate(filepath.Dir(socket)) != nil {
+		return false, nil
+	}
+	fi, err := os.Lstat(socket)
+	if err != nil || fi.Mode().Type() != os.ModeSocket || !ownedByUser(fi) {
+		return false, nil
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return false, nil
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return true, fmt.Errorf("cannot send request to server: %v", err)
+	}
+	var resp serverResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return true, fmt.Errorf("cannot read response from server: %v", err)
+	}
+	out.Write(resp.Stdout)
+	if resp.Error != "" {
+		return true, errors.New(resp.Error)
+	}
+	return true, nil
+}
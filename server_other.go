@@ -0,0 +1,28 @@
+//go:build !unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// checkPrivate returns an error unless dir is a directory, and not a
+// symbolic link. Its owner and permissions cannot be checked here, but
+// the default socket is in the user's own cache directory.
+func checkPrivate(dir string) error {
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	return nil
+}
+
+// ownedByUser reports whether the file described by fi belongs to the
+// user, which cannot be checked here.
+func ownedByUser(fi os.FileInfo) bool {
+	return true
+}
@@ -0,0 +1,33 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// checkPrivate returns an error unless dir is a directory, and not a
+// symbolic link, that belongs to the user and that no one else can use.
+func checkPrivate(dir string) error {
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !fi.IsDir():
+		return fmt.Errorf("%s is not a directory", dir)
+	case !ownedByUser(fi):
+		return fmt.Errorf("%s belongs to another user", dir)
+	case fi.Mode().Perm()&0077 != 0:
+		return fmt.Errorf("%s can be used by other users (mode %v)", dir, fi.Mode().Perm())
+	}
+	return nil
+}
+
+// ownedByUser reports whether the file described by fi belongs to the user.
+func ownedByUser(fi os.FileInfo) bool {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	return ok && int(st.Uid) == os.Getuid()
+}
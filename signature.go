@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/printer"
+	"go/scanner"
+	"go/token"
+	"go/types"
+	"io"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/packages"
+)
+
+// Signature is the signature help printed by -signature. The position
+// is that of the callee's declaration, if it is known. Active is the
+// index in Params of the parameter for the argument at the cursor.
+type Signature struct {
+	Position
+	Name      string   `json:"name"`
+	Signature string   `json:"signature"`
+	Params    []string `json:"params"`
+	Active    int      `json:"active"`
+	Doc       string   `json:"doc,omitempty"`
+}
+
+// godefSignature returns the signature of the function called by the
+// innermost call expression whose argument list contains searchpos.
+func godefSignature(cfg *packages.Config, filename string, src []byte, searchpos int) (*Signature, error) {
+	lpkgs, err := loadSyntax(cfg, src, filename, "file="+filename)
+	if err != nil {
+		return nil, err
+	}
+	isInputFile := newFileCompare(filename)
+	for _, lpkg := range lpkgs {
+		for _, f := range lpkg.Syntax {
+			tfile := lpkg.Fset.File(f.Pos())
+			if tfile == nil || !isInputFile(tfile.Name()) {
+				continue
+			}
+			if searchpos < 0 || searchpos > tfile.Size() {
+				return nil, fmt.Errorf("cursor %d is beyond end of file %s (%d)", searchpos, tfile.Name(), tfile.Size())
+			}
+			return callSignature(lpkg, f, src, searchpos)
+		}
+	}
+	return nil, fmt.Errorf("There must be at least one package that contains the file")
+}
+
+// callSignature returns the signature help for the call enclosing the
+// offset searchpos in f, whose contents are src.
+func callSignature(lpkg *packages.Package, f *ast.File, src []byte, searchpos int) (*Signature, error) {
+	tfile := lpkg.Fset.File(f.Pos())
+	pos := tfile.Pos(searchpos)
+	path, _ := astutil.PathEnclosingInterval(f, pos, pos)
+	var call *ast.CallExpr
+	for _, n := range path {
+		if c, ok := n.(*ast.CallExpr); ok && c.Lparen < pos && pos <= c.Rparen {
+			call = c
+			break
+		}
+	}
+	if call == nil {
+		return nil, fmt.Errorf("no function call at the cursor")
+	}
+	args := src[tfile.Offset(call.Lparen)+1 : searchpos]
+	if b := builtinCallee(lpkg.TypesInfo, call); b != nil {
+		result, variadic, err := builtinSignature(b, tfile.Name(), src)
+		if err != nil {
+			return nil, err
+		}
+		result.Active = activeParam(args, len(result.Params), variadic)
+		return result, nil
+	}
+	tv, ok := lpkg.TypesInfo.Types[call.Fun]
+	if !ok || tv.IsType() {
+		return nil, fmt.Errorf("%s is not a function", types.ExprString(call.Fun))
+	}
+	sig, ok := tv.Type.Underlying().(*types.Signature)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a function", types.ExprString(call.Fun))
+	}
+
+	qualifier := func(pkg *types.Package) string {
+		if pkg == lpkg.Types {
+			return ""
+		}
+		return pkg.Name()
+	}
+	result := &Signature{
+		Name: types.ExprString(call.Fun),
+	}
+	if _, fn := callee(lpkg.TypesInfo, call); fn != nil {
+		fn = fn.Origin()
+		result.Name = funcName(fn, lpkg.Types)
+		result.Position = objToPos(lpkg.Fset, fn)
+		result.Doc = docComment(result.Position, tfile.Name(), src)
+	}
+	// The signature is that of this call, with any
+	// type arguments substituted.
+	result.Signature = result.Name + strings.TrimPrefix(types.TypeString(sig, qualifier), "func")
+	params := sig.Params()
+	for i := 0; i < params.Len(); i++ {
+		p := params.At(i)
+		t := types.TypeString(p.Type(), qualifier)
+		if s, ok := p.Type().(*types.Slice); ok && sig.Variadic() && i == params.Len()-1 {
+			t = "..." + types.TypeString(s.Elem(), qualifier)
+		}
+		if p.Name() != "" {
+			t = p.Name() + " " + t
+		}
+		result.Params = append(result.Params, t)
+	}
+	result.Active = activeParam(args, params.Len(), sig.Variadic())
+	return result, nil
+}
+
+// builtinCallee returns the builtin function called by call,
+// or nil if call is not a call of a builtin function.
+func builtinCallee(info *types.Info, call *ast.CallExpr) *types.Builtin {
+	var id *ast.Ident
+	switch f := ast.Unparen(call.Fun).(type) {
+	case *ast.Ident:
+		id = f
+	case *ast.SelectorExpr:
+		id = f.Sel
+	default:
+		return nil
+	}
+	b, _ := info.Uses[id].(*types.Builtin)
+	return b
+}
+
+// builtinSignature returns the signature help for a call of the builtin
+// function b, which has no signature of its own, from its declaration in
+// the source of package builtin or unsafe, and whether it is variadic.
+// The doc comment is found as for a call in filename, whose contents
+// are src.
+func builtinSignature(b *types.Builtin, filename string, src []byte) (*Signature, bool, error) {
+	pkg, name := "builtin", b.Name()
+	if b.Pkg() == types.Unsafe {
+		pkg, name = "unsafe", "unsafe."+b.Name()
+	}
+	decls := predeclaredDecls(pkg)
+	decl := decls.funcs[b.Name()]
+	if decl == nil {
+		return nil, false, fmt.Errorf("no declaration found for %s", name)
+	}
+	result := &Signature{
+		Position: decls.pos[b.Name()],
+		Name:     name,
+	}
+	result.Doc = docComment(result.Position, filename, src)
+	var buf bytes.Buffer
+	printer.Fprint(&buf, decls.fset, decl.Type)
+	result.Signature = name + strings.TrimPrefix(buf.String(), "func")
+	variadic := false
+	for _, field := range decl.Type.Params.List {
+		buf.Reset()
+		printer.Fprint(&buf, decls.fset, field.Type)
+		_, variadic = field.Type.(*ast.Ellipsis)
+		if len(field.Names) == 0 {
+			result.Params = append(result.Params, buf.String())
+		}
+		for _, id := range field.Names {
+			result.Params = append(result.Params, id.Name+" "+buf.String())
+		}
+	}
+	return result, variadic, nil
+}
+
+// activeParam returns the index, among nparams parameters, of the one
+// for the argument that follows args, the beginning of an argument list.
+// The arguments that follow the last of a variadic function's parameters
+// are all for that one.
+func activeParam(args []byte, nparams int, variadic bool) int {
+	active := argIndex(args)
+	if variadic && active >= nparams {
+		active = nparams - 1
+	}
+	return active
+}
+
+// argIndex returns the index of the argument that follows the source
+// text args, the beginning of an argument list: the number of commas
+// in it that are not nested in parentheses, brackets or braces.
+func argIndex(args []byte) int {
+	var s scanner.Scanner
+	fset := token.NewFileSet()
+	s.Init(fset.AddFile("", -1, len(args)), args, nil, 0)
+	index, depth := 0, 0
+	for {
+		_, tok, _ := s.Scan()
+		switch tok {
+		case token.EOF:
+			return index
+		case token.LPAREN, token.LBRACK, token.LBRACE:
+			depth++
+		case token.RPAREN, token.RBRACK, token.RBRACE:
+			depth--
+		case token.COMMA:
+			if depth == 0 {
+				index++
+			}
+		}
+	}
+}
+
+// printSignature prints the signature, followed by its parameters
+// one per line, with the active one marked, and by its doc comment.
+// With -json, it is printed as a single JSON object.
+func printSignature(out io.Writer, sig *Signature) error {
+	if *jsonFlag {
+		if sig.Params == nil {
+			sig.Params = []string{}
+		}
+		jsonStr, err := json.Marshal(sig)
+		if err != nil {
+			return fmt.Errorf("JSON marshal error: %v", err)
+		}
+		fmt.Fprintf(out, "%s\n", jsonStr)
+		return nil
+	}
+	fmt.Fprintf(out, "%v\n%s\n", sig.Position, sig.Signature)
+	for i, p := range sig.Params {
+		fmt.Fprintf(out, "\t%s", p)
+		if i == sig.Active {
+			fmt.Fprint(out, "\t(active)")
+		}
+		fmt.Fprintln(out)
+	}
+	fmt.Fprint(out, sig.Doc)
+	return nil
+}
@@ -2,9 +2,10 @@
 
 package a
 
+// Stuff does things.
 func Stuff() { //@Stuff
 	x := 5
-	Random2(x) //@godef("dom2", Random2)
+	Random2(x) //@godef("dom2", Random2),mark(Random2Call, "Random2")
 	Random()   //@godef("()", Random)
 }
 
@@ -0,0 +1,18 @@
+package a
+
+func totalArea(shapes []Shape) int { //@mark(TotalArea, "totalArea"),godefCallers("totalArea", TotalAreaCall),godefCallees("totalArea", ShapeArea)
+	total := 0
+	for _, s := range shapes {
+		total += s.Area() //@mark(ShapeAreaCall, "Area")
+	}
+	return total
+}
+
+func squares() int { //@godefCallees("squares", SquareArea, TotalArea)
+	sq := Square{}
+	return sq.Area() + totalArea([]Shape{sq}) //@mark(SquareAreaCall, "Area"),mark(TotalAreaCall, "totalArea")
+}
+
+func areaFunc() func() int { //@godefCallees("areaFunc", SquareArea)
+	return Square{}.Area //@mark(SquareAreaValue, "Area")
+}
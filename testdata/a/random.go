@@ -5,16 +5,16 @@ func Random() int { //@Random
 	return y
 }
 
-func Random2(y int) int { //@Random2,mark(RandomParamY, "y")
-	return y //@godef("y", RandomParamY)
+func Random2(y int) int { //@Random2,mark(RandomParamY, "y"),godefRefs("Random2", Random2Call),godefRefs("y", RandomY)
+	return y //@godef("y", RandomParamY),mark(RandomY, "y")
 }
 
 type Pos struct {
-	x, y int //@mark(PosX, "x"),mark(PosY, "y")
+	x, y int //@mark(PosX, "x"),mark(PosY, "y"),godefRefs("x", PosSumX)
 }
 
 func (p *Pos) Sum() int { //@mark(PosSum, "Sum")
-	return p.x + p.y //@godef("x", PosX)
+	return p.x + p.y //@godef("x", PosX),mark(PosSumX, "x")
 }
 
 func _() {
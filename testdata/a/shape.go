@@ -0,0 +1,21 @@
+package a
+
+type Shape interface { //@Shape,godefImpl("Shape", Square, Circle)
+	Area() int //@mark(ShapeArea, "Area"),godefImpl("Area", SquareArea, CircleArea)
+}
+
+type Square struct { //@Square,godefImpl("Square", Shape)
+	side int
+}
+
+func (s Square) Area() int { //@mark(SquareArea, "Area"),godefCallers("Area", SquareAreaCall, ShapeAreaCall, SquareAreaValue)
+	return s.side * s.side
+}
+
+type Circle struct { //@Circle
+	r int
+}
+
+func (c *Circle) Area() int { //@mark(CircleArea, "Area"),godefImpl("Area", ShapeArea)
+	return 3 * c.r * c.r
+}
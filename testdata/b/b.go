@@ -22,3 +22,5 @@ func Bar() {
 	x.F2      //@godef("F2", S2F2)
 	x.S2.F1   //@godef("F1", S2F1)
 }
+
+func (*S2) Method() {} //@mark(S2Method, "Method")
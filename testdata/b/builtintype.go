@@ -0,0 +1,9 @@
+package b
+
+func builtinTypes() {
+	var e error                         //@godefBuiltinType("e", "error")
+	var n int                           //@godefBuiltinType("n", "int")
+	var x any                           //@godefBuiltinType("x", "any")
+	f := func() []string { return nil } //@godefBuiltinType("f", "string")
+	_, _, _, _ = e, n, x, f
+}
@@ -0,0 +1,14 @@
+package b
+
+import "github.com/bobg/godef/a"
+
+func typeDefs() {
+	var p *S1                                    //@godefType("p", S1)
+	var s []S2                                   //@godefType("s", S2)
+	var m map[string]*S1                         //@godefType("m", S1)
+	var c chan S2                                //@godefType("c", S2)
+	f := func() (*S1, error) { return nil, nil } //@godefType("f", S1)
+	var x S1                                     //@godefType("x", S1), godefType("S1", S1)
+	var sh []a.Shape                             //@godefType("sh", Shape)
+	_, _, _, _, _, _, _ = p, s, m, c, f, x, sh
+}
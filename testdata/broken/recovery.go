@@ -0,0 +1,34 @@
+package broken
+
+type brokenPoint struct {
+	brokenX, brokenY int //@brokenX
+}
+
+func unclosedBlock(p brokenPoint) {
+	if p.brokenX > 0 {
+		p.brokenY = 1
+
+func afterUnclosedBlock(q brokenPoint) int {
+	return q.brokenX //@godef("brokenX", brokenX)
+}
+
+func garbledStatements(p brokenPoint) {
+	garbled := p.brokenX + * ) ] //@garbled
+	_ = ) ( +
+	_ = ] [ -
+	_ = } {
+	_ = ) ( +
+	_ = ] [ -
+	_ = ) ( +
+	_ = ] [ -
+	_ = ) ( +
+	_ = ] [ -
+	_ = ) ( +
+	_ = garbled //@godef("garbled", garbled)
+}
+
+var afterGarbled brokenPoint //@afterGarbled
+
+func useAfterGarbled() {
+	_ = afterGarbled.brokenY //@godef("afterGarbled", afterGarbled)
+}
@@ -0,0 +1,20 @@
+package print
+
+func literals() {
+	const bin = 0b1010
+	const sep = 1_000_000 + 0o17
+	const hexFloat = 0x1p-2
+	_, _, _ = bin, sep, hexFloat //@mark(PrintBin, "bin"),mark(PrintSep, "sep"),mark(PrintHexFloat, "hexFloat")
+
+	/*@
+	godefPrint(PrintBin, "type", re`^(|
+		).*godef.print.literals\.go:\d+:\d+(\n|
+		)const bin (untyped )?int = 10\n$`)
+	godefPrint(PrintSep, "type", re`^(|
+		).*godef.print.literals\.go:\d+:\d+(\n|
+		)const sep (untyped )?int = 1000015\n$`)
+	godefPrint(PrintHexFloat, "type", re`^(|
+		).*godef.print.literals\.go:\d+:\d+(\n|
+		)const hexFloat (untyped )?float(64)? = 0\.25\n$`)
+	*/
+}
@@ -5,16 +5,18 @@ import (
 	"github.com/bobg/godef/b"
 )
 
+// localStruct is used for printing.
 type localStruct struct {
+	// Exported is documented.
 	Exported bool
 	private  bool
 }
 
 func printing() {
 start:
-	var thing localStruct
-	if thing.private {
-		thing.Exported = false
+	var thing localStruct //@mark(PrintLocalStruct, "localStruct")
+	if thing.private { //@mark(PrintPrivate, "private")
+		thing.Exported = false //@mark(PrintExported, "Exported")
 		goto start //@mark(PrintStart, "start")
 	}
 	a.Stuff()    //@mark(PrintA, "a"),mark(PrintStuff, "Stuff")
@@ -24,16 +26,16 @@ start:
 	}
 
 	/*@
-	godefPrint(PrintImportDir, "json", re`godef[/\\]a\s*$`)
+	godefPrint(PrintImportDir, "json", re`^{"version":1,"kind":"path","value":".*godef[/\\]+a"}\n$`)
 
 	godefPrint(PrintA, "json", re`^(|
-		){"filename":".*godef.print.print\.go","line":\d+,"column":\d+}\n$`)
+		){"filename":".*godef.print.print\.go","line":\d+,"column":\d+,"version":1,"name":"a","kind":"import",("pkg":"github\.com/bobg/godef/print",)?"value":"\\"github\.com/bobg/godef/a\\""}\n$`)
 	godefPrint(PrintA, "type", re`^(|
 		).*godef.print.print\.go:\d+:\d+(\n|
 		)import \(a "github\.com/bobg/godef/a"\)\n$`)
 
 	godefPrint(PrintStuff, "json", re`^(|
-		){"filename":".*godef.a.a\.go","line":\d+,"column":\d+}\n$`)
+		){"filename":".*godef.a.a\.go","line":\d+,"column":\d+,"version":1,"name":"Stuff","kind":"func","pkg":"github\.com/bobg/godef/a","type":"func\(\)"}\n$`)
 	godefPrint(PrintStuff, "type", re`^(|
 		).*godef.a.a\.go:\d+:\d+(\n|
 		).*Stuff func\(\)\n$`)
@@ -44,6 +46,22 @@ start:
 		).*godef.a.a\.go:\d+:\d+(\n|
 		).*Stuff func\(\)\n$`)
 
+	godefPrint(PrintStuff, "doc", re`^(|
+		).*godef.a.a\.go:\d+:\d+(\n|
+		)Stuff does things\.\n$`)
+	godefPrint(PrintStuff, "jsondoc", re`^(|
+		){"filename":".*godef.a.a\.go","line":\d+,"column":\d+,"version":1,"name":"Stuff","kind":"func","pkg":"github\.com/bobg/godef/a","type":"func\(\)","doc":"Stuff does things\.\\n"}\n$`)
+	godefPrint(PrintLocalStruct, "doc", re`^(|
+		).*godef.print.print\.go:\d+:\d+(\n|
+		)localStruct is used for printing\.\n$`)
+	godefPrint(PrintExported, "doc", re`^(|
+		).*godef.print.print\.go:\d+:\d+(\n|
+		)Exported is documented\.\n$`)
+	godefPrint(PrintPrivate, "doc", re`^(|
+		).*godef.print.print\.go:\d+:\d+\n$`)
+	godefPrint(PrintC1, "doc", re`^(|
+		).*godef.print.print\.go:\d+:\d+\n$`)
+
 	godefPrint(PrintC1, "type", re`^(|
 		).*godef.print.print\.go:\d+:\d+(\n|
 		)const c1 (untyped )?int = 5\n$`)
@@ -53,7 +71,7 @@ start:
 		)label start\n$`)
 
 	godefPrint(PrintS1, "json", re`^(|
-		){"filename":".*godef.b.b\.go","line":\d+,"column":\d+}\n$`)
+		){"filename":".*godef.b.b\.go","line":\d+,"column":\d+,"version":1,"name":"S1","kind":"type","pkg":"github\.com/bobg/godef/b","type":"struct\s*{.*}"}\n$`)
 	godefPrint(PrintS1, "type", re`^(|
 		).*godef.b.b\.go:\d+:\d+(\n|
 		)type S1 struct\s*\{\s*F1\s+int[\n;]\s*f2\s+int[\n;]\s*f3\s+S2[\n;]\s*S2\s*\}\n$`)
@@ -0,0 +1,7 @@
+//go:build integration
+
+package tags
+
+func integration() {
+	tagged()
+}
@@ -0,0 +1,7 @@
+package tags
+
+func Common() {}
+
+func Name() string {
+	return platformName()
+}
@@ -0,0 +1,5 @@
+package tags
+
+func platformName() string {
+	return "linux"
+}
@@ -0,0 +1,9 @@
+package tags
+
+func platform() {
+	Common()
+}
+
+func platformName() string {
+	return "windows"
+}